@@ -0,0 +1,174 @@
+package bincover
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// Label names the run in the coverage attribution TearDown computes. Runs sharing a label are attributed together,
+// and runs without a label are attributed to the empty label.
+func Label(label string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.label = label
+	}
+}
+
+// ForTest labels the run with the name of t, like Label(t.Name()).
+func ForTest(t testing.TB) CoverageCollectorOption {
+	return Label(t.Name())
+}
+
+// CoverageAttribution maps the labeled runs of a CoverageCollector to the source lines they covered.
+// Files are named like in coverage profiles: the import path of their package followed by their base name.
+// Queries taking a file name also accept any suffix of it starting after a "/", such as "cmd/root.go".
+// A line counts as covered by a run if any block spanning it was executed during the run.
+type CoverageAttribution struct {
+	mode string
+	// covered holds the lines covered by each run, keyed by label, then by file.
+	covered map[string]map[string]map[int]bool
+}
+
+// Attribution returns the coverage of every run, as computed by the last TearDown, or nil if TearDown has not
+// merged any coverage yet. Profiles added with AddProfile are not runs and are left out.
+func (c *CoverageCollector) Attribution() *CoverageAttribution {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attribution
+}
+
+func newCoverageAttribution(mode string, runProfiles map[string]*profile) (*CoverageAttribution, error) {
+	a := &CoverageAttribution{mode: mode, covered: make(map[string]map[string]map[int]bool)}
+	for label, p := range runProfiles {
+		files := make(map[string]map[int]bool)
+		for file := range p.files {
+			blocks, err := p.sortedBlocks(file)
+			if err != nil {
+				return nil, err
+			}
+			lines := make(map[int]bool)
+			for line, lc := range lineCoverages(blocks) {
+				if lc.Count > 0 {
+					lines[line] = true
+				}
+			}
+			if len(lines) > 0 {
+				files[file] = lines
+			}
+		}
+		a.covered[label] = files
+	}
+	return a, nil
+}
+
+// Labels returns the labels of the runs, in lexical order.
+func (a *CoverageAttribution) Labels() []string {
+	labels := make([]string, 0, len(a.covered))
+	for label := range a.covered {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// sortedLabels returns the labels of runProfiles in lexical order.
+func sortedLabels(runProfiles map[string]*profile) []string {
+	labels := make([]string, 0, len(runProfiles))
+	for label := range runProfiles {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// RunsCovering returns the labels of the runs that covered line of file, in lexical order.
+func (a *CoverageAttribution) RunsCovering(file string, line int) []string {
+	var labels []string
+	for _, label := range a.Labels() {
+		for name, lines := range a.covered[label] {
+			if matchesFile(name, file) && lines[line] {
+				labels = append(labels, label)
+				break
+			}
+		}
+	}
+	return labels
+}
+
+// CoveredLines returns the lines the run labeled label covered, keyed by file, in increasing order.
+func (a *CoverageAttribution) CoveredLines(label string) map[string][]int {
+	return a.filterLines(label, func(string, int) bool { return true })
+}
+
+// UniqueLines returns the lines covered by the run labeled label and by no other run, keyed by file,
+// in increasing order.
+func (a *CoverageAttribution) UniqueLines(label string) map[string][]int {
+	return a.filterLines(label, func(file string, line int) bool {
+		for other, files := range a.covered {
+			if other != label && files[file][line] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// RedundantRuns returns the labels of the runs that covered no line that another run didn't also cover,
+// in lexical order. A redundant run might still be worth keeping for the behavior it asserts.
+func (a *CoverageAttribution) RedundantRuns() []string {
+	var labels []string
+	for _, label := range a.Labels() {
+		if len(a.UniqueLines(label)) == 0 {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// filterLines returns the lines covered by the run labeled label for which keep returns true, keyed by file.
+func (a *CoverageAttribution) filterLines(label string, keep func(file string, line int) bool) map[string][]int {
+	result := make(map[string][]int)
+	for file, lines := range a.covered[label] {
+		for line := range lines {
+			if keep(file, line) {
+				result[file] = append(result[file], line)
+			}
+		}
+		sort.Ints(result[file])
+	}
+	return result
+}
+
+// matchesFile reports whether name, a file name from a profile, is file or ends with "/" followed by file.
+func matchesFile(name string, file string) bool {
+	return name == file || strings.HasSuffix(name, "/"+file)
+}
+
+type attributionJSON struct {
+	Mode string           `json:"mode"`
+	Runs []attributionRun `json:"runs"`
+}
+
+type attributionRun struct {
+	Label   string           `json:"label"`
+	Covered map[string][]int `json:"covered"`
+	Unique  map[string][]int `json:"unique"`
+}
+
+// WriteJSON writes the attribution to w as a JSON object holding the cover mode and, for every run,
+// its label and the lines it covered and covered uniquely, keyed by file:
+//
+//	{"mode": "set", "runs": [{"label": "TestLogin", "covered": {"example.com/app/login.go": [10, 11]}, "unique": {...}}]}
+func (a *CoverageAttribution) WriteJSON(w io.Writer) error {
+	out := attributionJSON{Mode: a.mode, Runs: []attributionRun{}}
+	for _, label := range a.Labels() {
+		out.Runs = append(out.Runs, attributionRun{Label: label, Covered: a.CoveredLines(label), Unique: a.UniqueLines(label)})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(out), "error writing coverage attribution")
+}
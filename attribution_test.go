@@ -0,0 +1,120 @@
+package bincover
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAttribution(t *testing.T) *CoverageAttribution {
+	runs := map[string]string{
+		"TestLogin":  "mode: set\nexample.com/app/login.go:1.1,3.2 2 1\nexample.com/app/login.go:4.1,5.2 1 0\nexample.com/app/util.go:1.1,1.10 1 1\n",
+		"TestLogout": "mode: set\nexample.com/app/login.go:1.1,3.2 2 0\nexample.com/app/login.go:4.1,5.2 1 1\nexample.com/app/util.go:1.1,1.10 1 1\n",
+		"TestUtil":   "mode: set\nexample.com/app/util.go:1.1,1.10 1 1\n",
+	}
+	runProfiles := make(map[string]*profile)
+	for label, content := range runs {
+		p, err := parseProfile(strings.NewReader(content))
+		require.NoError(t, err)
+		runProfiles[label] = p
+	}
+	a, err := newCoverageAttribution(set, runProfiles)
+	require.NoError(t, err)
+	return a
+}
+
+func TestCoverageAttribution_queries(t *testing.T) {
+	a := newTestAttribution(t)
+	require.Equal(t, []string{"TestLogin", "TestLogout", "TestUtil"}, a.Labels())
+	require.Equal(t, []string{"TestLogin"}, a.RunsCovering("example.com/app/login.go", 2))
+	require.Equal(t, []string{"TestLogout"}, a.RunsCovering("app/login.go", 5))
+	require.Equal(t, []string{"TestLogin", "TestLogout", "TestUtil"}, a.RunsCovering("util.go", 1))
+	require.Empty(t, a.RunsCovering("login.go", 10))
+	require.Empty(t, a.RunsCovering("gin.go", 1))
+	require.Equal(t, map[string][]int{"example.com/app/login.go": {1, 2, 3}, "example.com/app/util.go": {1}}, a.CoveredLines("TestLogin"))
+	require.Equal(t, map[string][]int{"example.com/app/login.go": {1, 2, 3}}, a.UniqueLines("TestLogin"))
+	require.Equal(t, map[string][]int{}, a.UniqueLines("TestUtil"))
+	require.Equal(t, []string{"TestUtil"}, a.RedundantRuns())
+}
+
+func TestCoverageAttribution_WriteJSON(t *testing.T) {
+	a := newTestAttribution(t)
+	var buf bytes.Buffer
+	require.NoError(t, a.WriteJSON(&buf))
+	want := `{
+  "mode": "set",
+  "runs": [
+    {
+      "label": "TestLogin",
+      "covered": {
+        "example.com/app/login.go": [
+          1,
+          2,
+          3
+        ],
+        "example.com/app/util.go": [
+          1
+        ]
+      },
+      "unique": {
+        "example.com/app/login.go": [
+          1,
+          2,
+          3
+        ]
+      }
+    },
+    {
+      "label": "TestLogout",
+      "covered": {
+        "example.com/app/login.go": [
+          4,
+          5
+        ],
+        "example.com/app/util.go": [
+          1
+        ]
+      },
+      "unique": {
+        "example.com/app/login.go": [
+          4,
+          5
+        ]
+      }
+    },
+    {
+      "label": "TestUtil",
+      "covered": {
+        "example.com/app/util.go": [
+          1
+        ]
+      },
+      "unique": {}
+    }
+  ]
+}
+`
+	require.Equal(t, want, buf.String())
+}
+
+func TestCoverageCollector_Attribution(t *testing.T) {
+	c := NewCoverageCollector(filepath.Join(t.TempDir(), "merged.out"), true)
+	require.NoError(t, c.Setup())
+	require.Nil(t, c.Attribution())
+	// set_covermode reports exit code 1, which RunBinary does not treat as an error.
+	output, _, err := c.RunBinary("./set_covermode", "TestRunMain", nil, nil, ForTest(t))
+	require.NoError(t, err)
+	require.Equal(t, helloWorldOutput, output)
+	output, _, err = c.RunBinary("./set_covermode", "TestRunMain", nil, nil, Label("other"))
+	require.NoError(t, err)
+	require.Equal(t, helloWorldOutput, output)
+	require.NoError(t, c.TearDown())
+	a := c.Attribution()
+	require.NotNil(t, a)
+	require.Equal(t, []string{"TestCoverageCollector_Attribution", "other"}, a.Labels())
+	require.Equal(t, []string{"TestCoverageCollector_Attribution", "other"}, a.RunsCovering("test_bins/set_covermode.go", 10))
+	require.Equal(t, []string{"TestCoverageCollector_Attribution", "other"}, a.RedundantRuns())
+}
@@ -0,0 +1,456 @@
+package bincover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBuildPackage  = "."
+	defaultBuildCoverPkg = "./..."
+	buildCacheDirName    = "bincover"
+	cachedBinaryName     = "bincover.test"
+	// Like the go build cache, cached binaries that haven't been used for buildCacheMaxAge are removed,
+	// at most once per buildCachePruneInterval, as recorded by the modification time of buildCachePruneFile.
+	buildCacheMaxAge        = 5 * 24 * time.Hour
+	buildCachePruneInterval = 24 * time.Hour
+	buildCachePruneFile     = "pruned.txt"
+	// DefaultMainTestName is the name of the test Build generates when GenerateEntrypoint is set.
+	// RunBinary runs it when no mainTestName is given.
+	DefaultMainTestName = "TestBincoverRunMain"
+	entrypointFilename  = "bincover_generated_main_test.go"
+	entrypointSource    = `// Code generated by bincover. DO NOT EDIT.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/confluentinc/bincover"
+)
+
+func ` + DefaultMainTestName + `(_ *testing.T) {
+	bincover.RunTest(main)
+}
+`
+)
+
+// BuildOptions configures Build.
+type BuildOptions struct {
+	// Package is the package containing the test that calls RunTest, as accepted by "go test". Defaults to ".".
+	Package string
+	// Tags are the build tags the package is built with, usually the tag guarding the test that calls RunTest.
+	Tags []string
+	// CoverPkg is the comma-separated list of package patterns to instrument. Defaults to "./...".
+	CoverPkg string
+	// CoverMode is one of "set", "count" or "atomic". If empty, the go tool's default is used.
+	CoverMode string
+	// Ldflags are passed to the linker, e.g. "-X main.version=1.0.0".
+	Ldflags string
+	// Output is the path the instrumented binary is written to. If empty, Build returns the binary's path
+	// in the build cache, which must not be modified.
+	Output string
+	// CacheDir is the directory builds are cached in. Defaults to a "bincover" directory in os.UserCacheDir.
+	// Binaries that haven't been used for five days are removed from it by later builds; the whole directory
+	// can be removed at any time to clear the cache.
+	CacheDir string
+	// GenerateEntrypoint makes Build add a DefaultMainTestName test calling RunTest(main) to the package,
+	// so that it doesn't have to be written by hand. The test is passed to the go tool with -overlay
+	// and never written to the source tree. The package must be a main package.
+	GenerateEntrypoint bool
+}
+
+// Build builds an instrumented binary for the package described by opts with "go test -c" and returns its path.
+// The package must contain a TestXxx function calling RunTest.
+//
+// Builds are cached by a hash of opts, the go toolchain and the source files of the package and its
+// dependencies, so repeated calls (including ones from separate "go test" runs) only rebuild the binary
+// when one of them changes.
+func Build(opts BuildOptions) (string, error) {
+	opts.setDefaults()
+	var overlay *buildOverlay
+	if opts.GenerateEntrypoint {
+		var err error
+		overlay, err = newEntrypointOverlay(opts)
+		if err != nil {
+			return "", err
+		}
+		defer overlay.remove()
+	}
+	pkgs, err := listBuildPackages(opts, overlay)
+	if err != nil {
+		return "", err
+	}
+	if _, err := findRunTestEntrypoint(pkgs[0], overlay); err != nil {
+		return "", err
+	}
+	key, err := buildCacheKey(opts, pkgs, overlay)
+	if err != nil {
+		return "", err
+	}
+	cacheDir := opts.CacheDir
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", errors.Wrap(err, "error finding build cache directory")
+		}
+		cacheDir = filepath.Join(userCacheDir, buildCacheDirName)
+	}
+	binDir := filepath.Join(cacheDir, key)
+	binPath := filepath.Join(binDir, cachedBinaryName)
+	if _, err := os.Stat(binPath); os.IsNotExist(err) {
+		if err := buildBinary(opts, overlay, binDir, binPath); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", errors.Wrap(err, "error reading build cache")
+	} else {
+		// The modification time of a binary's directory records when it was last used, for pruneBuildCache.
+		now := time.Now()
+		_ = os.Chtimes(binDir, now, now)
+	}
+	pruneBuildCache(cacheDir, time.Now())
+	if opts.Output == "" {
+		return binPath, nil
+	}
+	if err := copyExecutable(binPath, opts.Output); err != nil {
+		return "", errors.Wrapf(err, "error copying instrumented binary to \"%s\"", opts.Output)
+	}
+	return opts.Output, nil
+}
+
+func (opts *BuildOptions) setDefaults() {
+	if opts.Package == "" {
+		opts.Package = defaultBuildPackage
+	}
+	if opts.CoverPkg == "" {
+		opts.CoverPkg = defaultBuildCoverPkg
+	}
+}
+
+// buildFlags returns the go tool flags shared by every command run for opts, "go list" as well as "go test -c".
+func (opts *BuildOptions) buildFlags(overlay *buildOverlay) []string {
+	var flags []string
+	if len(opts.Tags) > 0 {
+		flags = append(flags, "-tags="+strings.Join(opts.Tags, ","))
+	}
+	return append(flags, overlay.flags()...)
+}
+
+func (opts *BuildOptions) goTestArgs(output string, overlay *buildOverlay) []string {
+	args := append([]string{"test", "-c", "-o", output, "-coverpkg=" + opts.CoverPkg}, opts.buildFlags(overlay)...)
+	if opts.CoverMode != "" {
+		args = append(args, "-covermode="+opts.CoverMode)
+	}
+	if opts.Ldflags != "" {
+		args = append(args, "-ldflags="+opts.Ldflags)
+	}
+	return append(args, opts.Package)
+}
+
+// buildPackage is the subset of "go list -json" output Build needs.
+type buildPackage struct {
+	ImportPath   string
+	Name         string
+	Dir          string
+	Standard     bool
+	GoFiles      []string
+	CgoFiles     []string
+	CFiles       []string
+	SFiles       []string
+	EmbedFiles   []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+func (p *buildPackage) sourceFiles() []string {
+	var files []string
+	for _, names := range [][]string{p.GoFiles, p.CgoFiles, p.CFiles, p.SFiles, p.EmbedFiles, p.TestGoFiles, p.XTestGoFiles} {
+		for _, name := range names {
+			files = append(files, filepath.Join(p.Dir, name))
+		}
+	}
+	return files
+}
+
+// listBuildPackages returns the package described by opts, followed by every package that ends up in its test binary.
+func listBuildPackages(opts BuildOptions, overlay *buildOverlay) ([]*buildPackage, error) {
+	pkg, err := listTargetPackage(opts, overlay)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := goList(opts, overlay, "-deps", "-test", opts.Package)
+	if err != nil {
+		return nil, err
+	}
+	coverDeps, err := goList(opts, overlay, append([]string{"-deps"}, strings.Split(opts.CoverPkg, ",")...)...)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]*buildPackage{pkg}, deps...), coverDeps...), nil
+}
+
+// listTargetPackage returns the package described by opts, which must match a single package.
+func listTargetPackage(opts BuildOptions, overlay *buildOverlay) (*buildPackage, error) {
+	pkgs, err := goList(opts, overlay, opts.Package)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, errors.Errorf("Build requires a single package, but \"%s\" matches %d packages", opts.Package, len(pkgs))
+	}
+	return pkgs[0], nil
+}
+
+func goList(opts BuildOptions, overlay *buildOverlay, args ...string) ([]*buildPackage, error) {
+	listArgs := append([]string{"list", "-json"}, opts.buildFlags(overlay)...)
+	cmd := exec.Command("go", append(listArgs, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing packages: %s", stderr.String())
+	}
+	var pkgs []*buildPackage
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		pkg := &buildPackage{}
+		err := decoder.Decode(pkg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding \"go list\" output")
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil, errors.Errorf("no packages matching %s", strings.Join(args, " "))
+	}
+	return pkgs, nil
+}
+
+// findRunTestEntrypoint returns the name of the first TestXxx function in pkg's test files that calls RunTest.
+func findRunTestEntrypoint(pkg *buildPackage, overlay *buildOverlay) (string, error) {
+	fset := token.NewFileSet()
+	testFiles := append(append([]string(nil), pkg.TestGoFiles...), pkg.XTestGoFiles...)
+	for _, name := range testFiles {
+		file, err := parser.ParseFile(fset, overlay.path(filepath.Join(pkg.Dir, name)), nil, 0)
+		if err != nil {
+			return "", errors.Wrap(err, "error parsing test file")
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Body == nil || !strings.HasPrefix(fn.Name.Name, "Test") {
+				continue
+			}
+			if callsRunTest(fn.Body) {
+				return fn.Name.Name, nil
+			}
+		}
+	}
+	return "", errors.Errorf("no test calling bincover.RunTest found in package %s", pkg.ImportPath)
+}
+
+func callsRunTest(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return !found
+		}
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			found = found || fun.Name == "RunTest"
+		case *ast.SelectorExpr:
+			found = found || fun.Sel.Name == "RunTest"
+		}
+		return !found
+	})
+	return found
+}
+
+// buildCacheKey hashes everything that affects the binary built for opts.
+func buildCacheKey(opts BuildOptions, pkgs []*buildPackage, overlay *buildOverlay) (string, error) {
+	h := sha256.New()
+	goEnv, err := exec.Command("go", "env", "GOVERSION", "GOOS", "GOARCH", "GOFLAGS", "CGO_ENABLED", "GOEXPERIMENT").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "error reading go environment")
+	}
+	h.Write(goEnv)
+	// The overlay flag names a temporary file, so only whether an overlay is used is part of the key.
+	for _, arg := range opts.goTestArgs("", nil) {
+		fmt.Fprintf(h, "arg %q\n", arg)
+	}
+	fmt.Fprintf(h, "generate entrypoint %t\n", overlay != nil)
+	seen := make(map[string]bool)
+	var files []string
+	for _, pkg := range pkgs {
+		// The generated main package of the test binary is derived from the other packages.
+		if pkg.Standard || strings.HasSuffix(pkg.ImportPath, ".test") {
+			continue
+		}
+		fmt.Fprintf(h, "package %q %q\n", pkg.ImportPath, pkg.Dir)
+		for _, file := range pkg.sourceFiles() {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		buf, err := os.ReadFile(overlay.path(file))
+		if err != nil {
+			return "", errors.Wrap(err, "error hashing source file")
+		}
+		fmt.Fprintf(h, "file %q %d\n", file, len(buf))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildBinary builds the binary into a temporary file next to binPath and renames it into place,
+// so that concurrent builds never observe a partially written binary.
+func buildBinary(opts BuildOptions, overlay *buildOverlay, binDir string, binPath string) error {
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return errors.Wrap(err, "error creating build cache directory")
+	}
+	tmpFile, err := os.CreateTemp(binDir, cachedBinaryName)
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary binary")
+	}
+	tmpPath := tmpFile.Name()
+	_ = tmpFile.Close()
+	defer os.Remove(tmpPath)
+	cmd := exec.Command("go", opts.goTestArgs(tmpPath, overlay)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "error building instrumented binary for package %s:\n%s", opts.Package, output)
+	}
+	return errors.Wrap(os.Rename(tmpPath, binPath), "error moving instrumented binary into build cache")
+}
+
+// pruneBuildCache removes the binaries in cacheDir that haven't been used for buildCacheMaxAge, unless the cache
+// has already been pruned within buildCachePruneInterval. Errors are only logged, since the cache still works.
+func pruneBuildCache(cacheDir string, now time.Time) {
+	pruneFile := filepath.Join(cacheDir, buildCachePruneFile)
+	if info, err := os.Stat(pruneFile); err == nil && now.Sub(info.ModTime()) < buildCachePruneInterval {
+		return
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		log.Printf("error pruning build cache: %s\n", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < buildCacheMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cacheDir, entry.Name())); err != nil {
+			log.Printf("error pruning build cache: %s\n", err)
+		}
+	}
+	if err := os.WriteFile(pruneFile, []byte(now.Format(time.RFC3339)+"\n"), 0644); err != nil {
+		log.Printf("error pruning build cache: %s\n", err)
+		return
+	}
+	_ = os.Chtimes(pruneFile, now, now)
+}
+
+// buildOverlay holds the files passed to the go tool with -overlay. A nil *buildOverlay is an empty overlay.
+type buildOverlay struct {
+	dir string
+	// replace maps paths in the source tree to the files replacing them.
+	replace map[string]string
+	// configFile is the -overlay JSON file describing replace.
+	configFile string
+}
+
+// newEntrypointOverlay returns an overlay adding the generated RunTest entrypoint to the package described by opts.
+func newEntrypointOverlay(opts BuildOptions) (*buildOverlay, error) {
+	pkg, err := listTargetPackage(opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Name != "main" {
+		return nil, errors.Errorf("cannot generate RunTest entrypoint for package %s: not a main package", pkg.ImportPath)
+	}
+	dir, err := os.MkdirTemp("", "bincover_overlay")
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating overlay directory")
+	}
+	overlay := &buildOverlay{
+		dir:        dir,
+		replace:    map[string]string{filepath.Join(pkg.Dir, entrypointFilename): filepath.Join(dir, entrypointFilename)},
+		configFile: filepath.Join(dir, "overlay.json"),
+	}
+	if err := overlay.write(); err != nil {
+		overlay.remove()
+		return nil, err
+	}
+	return overlay, nil
+}
+
+func (o *buildOverlay) write() error {
+	for _, replacement := range o.replace {
+		if err := os.WriteFile(replacement, []byte(entrypointSource), 0600); err != nil {
+			return errors.Wrap(err, "error writing generated entrypoint")
+		}
+	}
+	config, err := json.Marshal(struct{ Replace map[string]string }{o.replace})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.WriteFile(o.configFile, config, 0600), "error writing overlay file")
+}
+
+// flags returns the go tool flags passing the overlay.
+func (o *buildOverlay) flags() []string {
+	if o == nil {
+		return nil
+	}
+	return []string{"-overlay=" + o.configFile}
+}
+
+// path returns the file the go tool reads in place of name.
+func (o *buildOverlay) path(name string) string {
+	if o != nil {
+		if replacement, ok := o.replace[name]; ok {
+			return replacement
+		}
+	}
+	return name
+}
+
+func (o *buildOverlay) remove() {
+	if err := os.RemoveAll(o.dir); err != nil {
+		log.Printf("error removing overlay directory: %s\n", err)
+	}
+}
+
+func copyExecutable(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return copyFile(src, dst, 0755)
+}
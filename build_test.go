@@ -0,0 +1,134 @@
+package bincover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          BuildOptions
+		mainTestName  string
+		wantOutput    bool
+		wantRunOutput string
+		wantExitCode  int
+		wantErr       bool
+		errMessage    string
+	}{
+		{
+			name:          "succeed building package with RunTest entrypoint",
+			opts:          BuildOptions{Package: "./test_bins", Tags: []string{"testrunmain"}},
+			mainTestName:  "TestRunMain",
+			wantRunOutput: helloWorldOutput,
+			wantExitCode:  1,
+		},
+		{
+			name:          "succeed building package into output path",
+			opts:          BuildOptions{Package: "./test_bins", Tags: []string{"testrunmain"}, CoverMode: count},
+			mainTestName:  "TestRunMain",
+			wantOutput:    true,
+			wantRunOutput: helloWorldOutput,
+			wantExitCode:  1,
+		},
+		{
+			name:          "succeed building package with generated entrypoint",
+			opts:          BuildOptions{Package: "./test_bins/os_exit", GenerateEntrypoint: true},
+			wantRunOutput: "Exiting with code 3\n",
+			wantExitCode:  3,
+		},
+		{
+			name:       "fail building package without RunTest entrypoint",
+			opts:       BuildOptions{Package: "./test_bins"},
+			wantErr:    true,
+			errMessage: "no test calling bincover.RunTest found in package github.com/confluentinc/bincover/test_bins",
+		},
+		{
+			name:       "fail building multiple packages",
+			opts:       BuildOptions{Package: "./test_bins/...", Tags: []string{"testrunmain"}},
+			wantErr:    true,
+			errMessage: "Build requires a single package, but \"./test_bins/...\" matches 5 packages",
+		},
+		{
+			name:       "fail generating entrypoint for non-main package",
+			opts:       BuildOptions{Package: ".", GenerateEntrypoint: true},
+			wantErr:    true,
+			errMessage: "cannot generate RunTest entrypoint for package github.com/confluentinc/bincover: not a main package",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.CacheDir = t.TempDir()
+			if tt.wantOutput {
+				tt.opts.Output = filepath.Join(t.TempDir(), "instr_bin")
+			}
+			binPath, err := Build(tt.opts)
+			if tt.wantErr {
+				require.EqualError(t, err, tt.errMessage)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantOutput {
+				require.Equal(t, tt.opts.Output, binPath)
+			} else {
+				require.Equal(t, tt.opts.CacheDir, filepath.Dir(filepath.Dir(binPath)))
+			}
+			c := NewCoverageCollector(filepath.Join(t.TempDir(), "merged.out"), true)
+			require.NoError(t, c.Setup())
+			output, exitCode, err := c.RunBinary(binPath, tt.mainTestName, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRunOutput, output)
+			require.Equal(t, tt.wantExitCode, exitCode)
+			require.NoError(t, c.TearDown())
+		})
+	}
+}
+
+func TestBuild_cached(t *testing.T) {
+	opts := BuildOptions{Package: "./test_bins", Tags: []string{"testrunmain"}, CacheDir: t.TempDir()}
+	binPath, err := Build(opts)
+	require.NoError(t, err)
+	info, err := os.Stat(binPath)
+	require.NoError(t, err)
+
+	cachedBinPath, err := Build(opts)
+	require.NoError(t, err)
+	require.Equal(t, binPath, cachedBinPath)
+	cachedInfo, err := os.Stat(cachedBinPath)
+	require.NoError(t, err)
+	require.Equal(t, info.ModTime(), cachedInfo.ModTime())
+
+	opts.Ldflags = "-s"
+	rebuiltBinPath, err := Build(opts)
+	require.NoError(t, err)
+	require.NotEqual(t, binPath, rebuiltBinPath)
+}
+
+func Test_pruneBuildCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	now := time.Now()
+	addEntry := func(name string, lastUsed time.Time) {
+		dir := filepath.Join(cacheDir, name)
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, cachedBinaryName), nil, 0755))
+		require.NoError(t, os.Chtimes(dir, lastUsed, lastUsed))
+	}
+	addEntry("unused", now.Add(-6*24*time.Hour))
+	addEntry("used", now.Add(-24*time.Hour))
+	pruneBuildCache(cacheDir, now)
+	require.NoDirExists(t, filepath.Join(cacheDir, "unused"))
+	require.DirExists(t, filepath.Join(cacheDir, "used"))
+	require.FileExists(t, filepath.Join(cacheDir, buildCachePruneFile))
+
+	// The cache is pruned at most once per interval.
+	addEntry("unused", now.Add(-6*24*time.Hour))
+	pruneBuildCache(cacheDir, now.Add(time.Hour))
+	require.DirExists(t, filepath.Join(cacheDir, "unused"))
+	pruneBuildCache(cacheDir, now.Add(buildCachePruneInterval))
+	require.NoDirExists(t, filepath.Join(cacheDir, "unused"))
+	require.DirExists(t, filepath.Join(cacheDir, "used"))
+}
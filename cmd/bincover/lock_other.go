@@ -0,0 +1,28 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const lockPollInterval = 50 * time.Millisecond
+
+// lockFile blocks until it creates the file at name, which no other process may hold, and returns the function
+// removing it. Unlike on Unix, a lock left behind by a process that died must be removed by hand.
+func lockFile(name string) (func(), error) {
+	for {
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_ = file.Close()
+			return func() { _ = os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "error creating lock file")
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
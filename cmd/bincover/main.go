@@ -0,0 +1,284 @@
+// Command bincover builds instrumented binaries, runs them and merges and reports their coverage
+// from the command line, so that integration suites written in other languages can collect Go coverage.
+//
+// Usage:
+//
+//	bincover build [-pkg package] [-tags tags] [-coverpkg patterns] [-covermode mode] [-ldflags flags] [-generate-entrypoint] [-o output]
+//	bincover run [-coverprofile file] [-test name] [-gocoverdir] [-timeout duration] [-env KEY=VALUE]... binary [args...]
+//	bincover merge -o output profile...
+//	bincover report [-html output] [-cobertura output] [-lcov output] profile
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/confluentinc/bincover"
+)
+
+const usage = `usage: bincover <command> [flags] [args]
+
+Commands:
+  build   build an instrumented binary and print its path
+  run     run an instrumented binary and record its coverage
+  merge   merge coverage profiles
+  report  report the coverage of a profile
+
+Run "bincover <command> -h" for the flags of a command.
+`
+
+type command func(args []string, stdout io.Writer, stderr io.Writer) (exitCode int, err error)
+
+var commands = map[string]command{
+	"build":  buildCommand,
+	"run":    runCommand,
+	"merge":  mergeCommand,
+	"report": reportCommand,
+}
+
+func main() {
+	os.Exit(runMain(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func runMain(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "bincover: unknown command \"%s\"\n%s", args[0], usage)
+		return 2
+	}
+	exitCode, err := cmd(args[1:], stdout, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "bincover %s: %s\n", args[0], err)
+		if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("bincover "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+// parseFlags parses args, returning exit code 2 for usage errors like the flag package does.
+func parseFlags(flags *flag.FlagSet, args []string) (int, error) {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0, err
+		}
+		return 2, err
+	}
+	return 0, nil
+}
+
+func buildCommand(args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	flags := newFlagSet("build", stderr)
+	var opts bincover.BuildOptions
+	var tags string
+	flags.StringVar(&opts.Package, "pkg", ".", "package containing main")
+	flags.StringVar(&tags, "tags", "", "comma-separated list of build tags")
+	flags.StringVar(&opts.CoverPkg, "coverpkg", "./...", "comma-separated list of package patterns to instrument")
+	flags.StringVar(&opts.CoverMode, "covermode", "", "coverage mode: set, count or atomic")
+	flags.StringVar(&opts.Ldflags, "ldflags", "", "flags passed to the linker")
+	flags.BoolVar(&opts.GenerateEntrypoint, "generate-entrypoint", false, "generate the test calling bincover.RunTest(main)")
+	flags.StringVar(&opts.Output, "o", "", "output path (defaults to a path in the build cache)")
+	if exitCode, err := parseFlags(flags, args); err != nil {
+		return exitCode, err
+	}
+	if tags != "" {
+		opts.Tags = strings.Split(tags, ",")
+	}
+	binPath, err := bincover.Build(opts)
+	if err != nil {
+		return 1, err
+	}
+	fmt.Fprintln(stdout, binPath)
+	return 0, nil
+}
+
+func runCommand(args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	flags := newFlagSet("run", stderr)
+	var env envFlag
+	coverProfile := flags.String("coverprofile", "", "coverage profile to write; if it exists, the run's coverage is merged into it")
+	testName := flags.String("test", "", "name of the test calling bincover.RunTest (defaults to "+bincover.DefaultMainTestName+")")
+	useGoCoverDir := flags.Bool("gocoverdir", false, "run a binary built with \"go build -cover\" instead of a test binary")
+	timeout := flags.Duration("timeout", 0, "stop the binary if it runs longer than this")
+	flags.Var(&env, "env", "environment variable KEY=VALUE set for the binary (repeatable)")
+	if exitCode, err := parseFlags(flags, args); err != nil {
+		return exitCode, err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2, errors.New("missing binary")
+	}
+	// The run's coverage is written to a file of its own, and only merged into -coverprofile once the binary has
+	// exited, so that concurrent runs sharing the profile only wait for each other while merging.
+	var runProfile string
+	if *coverProfile != "" {
+		dir, err := os.MkdirTemp("", "bincover_run")
+		if err != nil {
+			return 1, err
+		}
+		defer os.RemoveAll(dir)
+		runProfile = filepath.Join(dir, "coverage.out")
+	}
+	collector := bincover.NewCoverageCollector(runProfile, *coverProfile != "")
+	collector.UseGoCoverDir = *useGoCoverDir
+	if err := collector.Setup(); err != nil {
+		return 1, err
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	var options []bincover.CoverageCollectorOption
+	if !isTerminal(os.Stdin) {
+		options = append(options, bincover.Stdin(os.Stdin))
+	}
+	// An unsuccessful exit is not an error, so that only the binary's own output and exit code pass through.
+	result, runErr := collector.Exec(ctx, flags.Arg(0), *testName, env, flags.Args()[1:], options...)
+	if err := collector.TearDown(); err != nil && runErr == nil {
+		runErr = err
+	}
+	if runErr == nil && *coverProfile != "" {
+		runErr = mergeIntoProfile(*coverProfile, runProfile)
+	}
+	if runErr != nil {
+		return 1, runErr
+	}
+	if _, err := io.WriteString(stdout, result.Stdout); err != nil {
+		return 1, err
+	}
+	if _, err := io.WriteString(stderr, result.Stderr); err != nil {
+		return 1, err
+	}
+	return result.ExitCode, nil
+}
+
+// mergeIntoProfile merges the coverage profile at runProfile, if the run wrote one, into the profile at profile.
+// The read, merge and write hold an exclusive lock on profile + ".lock", so that concurrent runs sharing profile
+// don't overwrite each other's coverage. The lock file is left in place.
+func mergeIntoProfile(profile string, runProfile string) error {
+	if _, err := os.Stat(runProfile); os.IsNotExist(err) {
+		return nil
+	}
+	unlock, err := lockFile(profile + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	profiles := []string{runProfile}
+	if _, err := os.Stat(profile); err == nil {
+		profiles = append([]string{profile}, profiles...)
+	}
+	return bincover.Merge(profile, profiles...)
+}
+
+// isTerminal reports whether file is a terminal, which the run command doesn't forward to the binary, so that it
+// never waits on input typed by the user. Character devices other than terminals, like the null device, have nothing
+// to forward anyway.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// envFlag collects the values of a repeated -env flag.
+type envFlag []string
+
+func (e *envFlag) String() string {
+	return strings.Join(*e, " ")
+}
+
+func (e *envFlag) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return errors.Errorf("environment variable \"%s\" must have the form KEY=VALUE", value)
+	}
+	*e = append(*e, value)
+	return nil
+}
+
+func mergeCommand(args []string, _ io.Writer, stderr io.Writer) (int, error) {
+	flags := newFlagSet("merge", stderr)
+	output := flags.String("o", "", "merged coverage profile to write")
+	if exitCode, err := parseFlags(flags, args); err != nil {
+		return exitCode, err
+	}
+	if *output == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2, errors.New("an output file and at least one profile are required")
+	}
+	return 0, bincover.Merge(*output, flags.Args()...)
+}
+
+func reportCommand(args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	flags := newFlagSet("report", stderr)
+	htmlOutput := flags.String("html", "", "write an HTML report to this file instead of printing per-function coverage")
+	coberturaOutput := flags.String("cobertura", "", "write a Cobertura XML report to this file instead of printing per-function coverage")
+	lcovOutput := flags.String("lcov", "", "write an LCOV tracefile to this file instead of printing per-function coverage")
+	if exitCode, err := parseFlags(flags, args); err != nil {
+		return exitCode, err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2, errors.New("exactly one profile is required")
+	}
+	reports := []struct {
+		output string
+		write  func(profileFilename string, w io.Writer) error
+	}{
+		{*htmlOutput, bincover.HTMLReport},
+		{*coberturaOutput, bincover.CoberturaReport},
+		{*lcovOutput, bincover.LCOVReport},
+	}
+	wroteReport := false
+	for _, report := range reports {
+		if report.output == "" {
+			continue
+		}
+		if err := writeReport(flags.Arg(0), report.output, report.write); err != nil {
+			return 1, err
+		}
+		wroteReport = true
+	}
+	if wroteReport {
+		return 0, nil
+	}
+	cmd := exec.Command("go", "tool", "cover", "-func="+flags.Arg(0))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return 1, errors.Wrap(err, "error running \"go tool cover\"")
+	}
+	return 0, nil
+}
+
+func writeReport(profile string, output string, write func(profileFilename string, w io.Writer) error) error {
+	file, err := os.Create(output)
+	if err != nil {
+		return errors.Wrap(err, "error creating report")
+	}
+	if err := write(profile, file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/confluentinc/bincover"
+)
+
+func Test_runMain(t *testing.T) {
+	dir := t.TempDir()
+	writeProfile := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	setProfile := writeProfile("set.out", "mode: set\na.go:1.1,2.2 1 0\nb.go:1.1,2.2 1 1\n")
+	otherSetProfile := writeProfile("other_set.out", "mode: set\na.go:1.1,2.2 1 1\n")
+	countProfile := writeProfile("count.out", "mode: count\na.go:1.1,2.2 1 3\n")
+	bogusProfile := writeProfile("bogus.out", "mode: bogus\na.go:1.1,2.2 1 3\n")
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+		wantStderr string
+		// wantNoStderr checks that nothing is written to stderr, since an empty wantStderr isn't checked.
+		wantNoStderr bool
+		wantExitCode int
+		wantFile     string
+		wantContent  string
+	}{
+		{
+			name:         "fail running unknown command",
+			args:         []string{"frobnicate"},
+			wantStderr:   "bincover: unknown command \"frobnicate\"\n" + usage,
+			wantExitCode: 2,
+		},
+		{
+			name:        "succeed merging profiles",
+			args:        []string{"merge", "-o", filepath.Join(dir, "merged.out"), setProfile, otherSetProfile},
+			wantFile:    filepath.Join(dir, "merged.out"),
+			wantContent: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 1\n",
+		},
+		{
+			name:        "succeed merging profiles with different modes",
+			args:        []string{"merge", "-o", filepath.Join(dir, "mixed.out"), countProfile, setProfile},
+			wantFile:    filepath.Join(dir, "mixed.out"),
+			wantContent: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 1\n",
+		},
+		{
+			name:         "fail merging profiles with unknown mode",
+			args:         []string{"merge", "-o", filepath.Join(dir, "mismatch.out"), setProfile, bogusProfile},
+			wantStderr:   "bincover merge: error merging coverage profile \"" + bogusProfile + "\": cannot merge profiles with different coverage modes \"set\" and \"bogus\"\n",
+			wantExitCode: 1,
+		},
+		{
+			name:         "succeed running binary with nonzero exit code",
+			args:         []string{"run", "-gocoverdir", "../../test_bins/exit_1.sh"},
+			wantStdout:   "Hello world\n",
+			wantNoStderr: true,
+			wantExitCode: 1,
+		},
+		{
+			name:         "succeed running test binary with unsuccessful exit",
+			args:         []string{"run", "../../test_bins/exit_1.sh"},
+			wantStdout:   "Hello world\n",
+			wantNoStderr: true,
+			wantExitCode: 1,
+		},
+		{
+			name:         "succeed running binary killed by a signal",
+			args:         []string{"run", "-gocoverdir", "../../test_bins/kill_self.sh"},
+			wantStdout:   "Hello world\n",
+			wantNoStderr: true,
+			wantExitCode: -1,
+		},
+		{
+			name:         "fail running without binary",
+			args:         []string{"run", "-coverprofile", filepath.Join(dir, "run.out")},
+			wantExitCode: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			exitCode := runMain(tt.args, &stdout, &stderr)
+			require.Equal(t, tt.wantExitCode, exitCode)
+			require.Equal(t, tt.wantStdout, stdout.String())
+			if tt.wantStderr != "" || tt.wantNoStderr {
+				require.Equal(t, tt.wantStderr, stderr.String())
+			}
+			if tt.wantFile != "" {
+				buf, err := os.ReadFile(tt.wantFile)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantContent, string(buf))
+			}
+		})
+	}
+}
+
+func Test_runCommand_mergesRuns(t *testing.T) {
+	binPath, err := bincover.Build(bincover.BuildOptions{
+		Package:   "../../test_bins",
+		Tags:      []string{"testrunmain"},
+		CoverPkg:  "github.com/confluentinc/bincover/...",
+		CoverMode: "count",
+		CacheDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	coverProfile := filepath.Join(t.TempDir(), "coverage.out")
+	for i := 0; i < 2; i++ {
+		var stdout, stderr bytes.Buffer
+		exitCode := runMain([]string{"run", "-coverprofile", coverProfile, "-test", "TestRunMain", binPath}, &stdout, &stderr)
+		require.Equal(t, 1, exitCode, stderr.String())
+		require.Equal(t, "Hello world\n", stdout.String())
+	}
+	buf, err := os.ReadFile(coverProfile)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "test_bins/set_covermode.go:10.2,12.1 2 2\n")
+}
+
+func Test_mergeIntoProfile_concurrent(t *testing.T) {
+	dir := t.TempDir()
+	profile := filepath.Join(dir, "coverage.out")
+	const runs = 20
+	errs := make(chan error, runs)
+	for i := 0; i < runs; i++ {
+		runProfile := filepath.Join(dir, fmt.Sprintf("run%d.out", i))
+		require.NoError(t, os.WriteFile(runProfile, []byte(fmt.Sprintf("mode: set\nfile%d.go:1.1,2.2 1 1\n", i)), 0600))
+		go func() {
+			errs <- mergeIntoProfile(profile, runProfile)
+		}()
+	}
+	for i := 0; i < runs; i++ {
+		require.NoError(t, <-errs)
+	}
+	buf, err := os.ReadFile(profile)
+	require.NoError(t, err)
+	for i := 0; i < runs; i++ {
+		require.Contains(t, string(buf), fmt.Sprintf("file%d.go:1.1,2.2 1 1\n", i))
+	}
+}
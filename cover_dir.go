@@ -0,0 +1,171 @@
+package bincover
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTmpCoverDirPrefix = "temp_covdata"
+	goCoverDirEnvVar         = "GOCOVERDIR"
+	// Limits the number of directories passed to a single "go tool covdata" invocation,
+	// so that the -i flag stays well below the OS limit on the length of a single argument.
+	maxCoverDirsPerConversion = 100
+)
+
+// runCoverDirBinary runs a binary built with "go build -cover", writing its coverage data to a fresh GOCOVERDIR.
+// Unlike test binaries, these binaries report their exit code directly, so a nonzero exit is not treated as an error.
+// A binary killed by a signal is, but its result is returned along with the error.
+func (c *CoverageCollector) runCoverDirBinary(ctx context.Context, cfg runConfig, binPath string, env []string, args []string) (*RunResult, error) {
+	// GOCOVERDIR is set even when coverage is not collected, since binaries built with -cover warn when it is missing.
+	coverDir, err := os.MkdirTemp("", defaultTmpCoverDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sandbox, err := cfg.newSandbox()
+	if err != nil {
+		removeTempCoverDir(coverDir)
+		return nil, err
+	}
+	defer sandbox.remove()
+	cmd := exec.Command(binPath, args...)
+	cmd.Env = cfg.commandEnv(sandbox, env, coverDir)
+	if err := cfg.setupDir(cmd, sandbox); err != nil {
+		removeTempCoverDir(coverDir)
+		return nil, err
+	}
+	closeStdin, err := cfg.setStdin(cmd)
+	if err != nil {
+		removeTempCoverDir(coverDir)
+		return nil, err
+	}
+	defer closeStdin()
+	for _, cmdFunc := range cfg.preCmdFuncs {
+		if err := cmdFunc(cmd); err != nil {
+			removeTempCoverDir(coverDir)
+			return nil, err
+		}
+	}
+	output, err := runCmd(ctx, cmd, c.TerminationGracePeriod, cfg.combineOutput)
+	if _, ok := err.(*TimeoutError); ok {
+		c.keepPartialCoverDir(coverDir, cfg.label)
+		return nil, err
+	}
+	exitError, isExitError := err.(*exec.ExitError)
+	if err != nil && !isExitError {
+		removeTempCoverDir(coverDir)
+		format := "unexpected error running command \"%s\""
+		return nil, errors.Wrapf(err, format, binPath)
+	}
+	result := output.result()
+	result.Args, result.Env = args, cmd.Env
+	if isExitError && exitError.ExitCode() == -1 {
+		result.ExitCode, result.Signal = -1, exitSignal(exitError)
+		if result.SandboxFiles, err = sandbox.files(); err != nil {
+			removeTempCoverDir(coverDir)
+			return nil, err
+		}
+		if c.keepPartialCoverDir(coverDir, cfg.label) {
+			result.CoverProfile = coverDir
+		}
+		format := "unexpected error running command \"%s\""
+		return result, errors.Wrapf(exitError, format, binPath)
+	}
+	if isExitError {
+		result.ExitCode = exitError.ExitCode()
+	}
+	if result.SandboxFiles, err = sandbox.files(); err != nil {
+		removeTempCoverDir(coverDir)
+		return nil, err
+	}
+	if c.CollectCoverage {
+		c.mu.Lock()
+		c.tmpCoverDirs = append(c.tmpCoverDirs, coverDir)
+		c.setRunLabel(coverDir, cfg.label)
+		c.mu.Unlock()
+		result.CoverProfile = coverDir
+	} else {
+		removeTempCoverDir(coverDir)
+	}
+	for _, cmdFunc := range cfg.postCmdFuncs {
+		if e := cmdFunc(cmd, result.Combined, nil); e != nil {
+			return nil, e
+		}
+	}
+	return result, nil
+}
+
+// keepPartialCoverDir keeps the coverage data of a binary that did not exit normally
+// if the binary managed to write its counters, and removes it otherwise. It reports whether dir was kept.
+func (c *CoverageCollector) keepPartialCoverDir(dir string, label string) bool {
+	counterFiles, err := filepath.Glob(filepath.Join(dir, "covcounters.*"))
+	if !c.CollectCoverage || err != nil || len(counterFiles) == 0 {
+		removeTempCoverDir(dir)
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tmpCoverDirs = append(c.tmpCoverDirs, dir)
+	c.setRunLabel(dir, label)
+	return true
+}
+
+// profileFromCoverDirs converts the covmeta and covcounters files in dirs into a single text profile
+// using "go tool covdata textfmt". It returns nil if none of the directories contain coverage data.
+func profileFromCoverDirs(dirs []string) (*profile, error) {
+	var nonEmptyDirs []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading temp coverage directory")
+		}
+		if len(entries) > 0 {
+			nonEmptyDirs = append(nonEmptyDirs, dir)
+		}
+	}
+	var merged *profile
+	for len(nonEmptyDirs) > 0 {
+		n := maxCoverDirsPerConversion
+		if n > len(nonEmptyDirs) {
+			n = len(nonEmptyDirs)
+		}
+		p, err := convertCoverDirs(nonEmptyDirs[:n])
+		if err != nil {
+			return nil, err
+		}
+		nonEmptyDirs = nonEmptyDirs[n:]
+		if merged == nil {
+			merged = p
+		} else if err := merged.merge(p); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+func convertCoverDirs(dirs []string) (*profile, error) {
+	textFile, err := os.CreateTemp("", defaultTmpCoverageFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempCoverageFile(textFile.Name())
+	defer textFile.Close()
+	cmd := exec.Command("go", "tool", "covdata", "textfmt", "-i="+strings.Join(dirs, ","), "-o="+textFile.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "error converting coverage data with \"go tool covdata\": %s", output)
+	}
+	return parseProfile(textFile)
+}
+
+func removeTempCoverDir(name string) {
+	err := os.RemoveAll(name)
+	if err != nil {
+		log.Printf("error removing temp coverage directory: %s\n", err)
+	}
+}
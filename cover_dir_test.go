@@ -0,0 +1,106 @@
+package bincover
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// buildCoverBinary builds test_bins with "go build -cover", skipping the test on toolchains older than Go 1.20.
+func buildCoverBinary(t *testing.T) string {
+	if !hasReleaseTag("go1.20") {
+		t.Skip("go build -cover requires Go 1.20 or above")
+	}
+	binPath := filepath.Join(t.TempDir(), "set_covermode_cover")
+	output, err := exec.Command("go", "build", "-cover", "-coverpkg=./...", "-o", binPath, "./test_bins").CombinedOutput()
+	require.NoError(t, err, string(output))
+	return binPath
+}
+
+func hasReleaseTag(tag string) bool {
+	for _, releaseTag := range build.Default.ReleaseTags {
+		if releaseTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCoverageCollector_RunBinary_UseGoCoverDir(t *testing.T) {
+	coverBinPath := buildCoverBinary(t)
+	tests := []struct {
+		name             string
+		binPath          string
+		collectCoverage  bool
+		wantOutput       string
+		wantExitCode     int
+		wantErr          bool
+		errMessage       string
+		wantCoverDirs    int
+		wantMergedPrefix string
+	}{
+		{
+			name:             "succeed running binary when coverage is enabled",
+			binPath:          coverBinPath,
+			collectCoverage:  true,
+			wantOutput:       helloWorldOutput,
+			wantCoverDirs:    1,
+			wantMergedPrefix: "mode: set\n",
+		},
+		{
+			name:       "succeed running binary when coverage is disabled",
+			binPath:    coverBinPath,
+			wantOutput: helloWorldOutput,
+		},
+		{
+			name:            "succeed running binary with nonzero exit code",
+			binPath:         "./test_bins/exit_1.sh",
+			collectCoverage: true,
+			wantOutput:      helloWorldOutput,
+			wantExitCode:    1,
+			wantCoverDirs:   1,
+		},
+		{
+			name:         "fail running nonexistent binary",
+			binPath:      "invalid.exec",
+			wantErr:      true,
+			errMessage:   "unexpected error running command \"invalid.exec\": exec: \"invalid.exec\": executable file not found in $PATH",
+			wantExitCode: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergedFilename := filepath.Join(t.TempDir(), "merged.out")
+			c := NewCoverageCollector(mergedFilename, tt.collectCoverage)
+			c.UseGoCoverDir = true
+			require.NoError(t, c.Setup())
+			output, exitCode, err := c.RunBinary(tt.binPath, "", nil, nil)
+			if tt.wantErr {
+				require.EqualError(t, err, tt.errMessage)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantOutput, output)
+			require.Equal(t, tt.wantExitCode, exitCode)
+			require.Len(t, c.tmpCoverDirs, tt.wantCoverDirs)
+			coverDirs := c.tmpCoverDirs
+			require.NoError(t, c.TearDown())
+			for _, dir := range coverDirs {
+				_, err := os.Stat(dir)
+				require.True(t, os.IsNotExist(err))
+			}
+			buf, err := os.ReadFile(mergedFilename)
+			if tt.wantMergedPrefix == "" {
+				require.True(t, os.IsNotExist(err))
+				return
+			}
+			require.NoError(t, err)
+			require.Regexp(t, "^"+tt.wantMergedPrefix, string(buf))
+			require.Contains(t, string(buf), "test_bins/set_covermode.go:10.2,12.1 2 1\n")
+		})
+	}
+}
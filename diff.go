@@ -0,0 +1,224 @@
+package bincover
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+	// maxDiffSteps bounds the steps middleSnake searches from each end, so that the time spent diffing texts with
+	// thousands of differences stays bounded. Past it, the differing lines are reported as removed, then added.
+	maxDiffSteps = 2000
+)
+
+// diffOp is a single line of a line diff: an unchanged line (' '), a removed line ('-') or an added line ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the differences between oldText and newText in unified diff format,
+// or an empty string if they are equal.
+func unifiedDiff(oldName string, newName string, oldText string, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	// oldLine and newLine are the 1-based line numbers of ops[i] in each text.
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine, newLine, i = oldLine+1, newLine+1, i+1
+			continue
+		}
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := hunkEnd(ops, i)
+		hunkOldStart, hunkNewStart := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(hunkOldStart, oldCount), hunkRange(hunkNewStart, newCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// hunkEnd returns the end of the hunk whose first change is ops[first]: the index after the last change that is
+// separated from the previous one by at most twice the context lines, plus the trailing context.
+func hunkEnd(ops []diffOp, first int) int {
+	lastChange := first
+	for i := first + 1; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		if i-lastChange-1 > 2*diffContextLines {
+			break
+		}
+		lastChange = i
+	}
+	end := lastChange + 1 + diffContextLines
+	if end > len(ops) {
+		end = len(ops)
+	}
+	return end
+}
+
+// hunkRange formats the start and length of a hunk's range, as in "@@ -start,count +start,count @@".
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		// An empty range starts at the line before it.
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits text into lines, each keeping its trailing newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit turning oldLines into newLines. It uses the linear space variant of Myers'
+// algorithm, so that large golden files don't need a table of every pair of lines. Texts with too many differences
+// to diff quickly get a longer edit; see maxDiffSteps.
+func diffLines(oldLines []string, newLines []string) []diffOp {
+	d := &differ{a: oldLines, b: newLines, ops: make([]diffOp, 0, len(oldLines)+len(newLines))}
+	d.diff(0, len(oldLines), 0, len(newLines))
+	return d.ops
+}
+
+// differ accumulates the edit turning a into b.
+type differ struct {
+	a, b []string
+	ops  []diffOp
+}
+
+// diff appends the edit turning a[aLo:aHi] into b[bLo:bHi].
+func (d *differ) diff(aLo int, aHi int, bLo int, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, diffOp{kind: ' ', line: d.a[aLo]})
+		aLo, bLo = aLo+1, bLo+1
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.ops = append(d.ops, diffOp{kind: '+', line: line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.ops = append(d.ops, diffOp{kind: '-', line: line})
+		}
+	default:
+		x, y, u, v, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+		if !ok {
+			for _, line := range d.a[aLo:aHi] {
+				d.ops = append(d.ops, diffOp{kind: '-', line: line})
+			}
+			for _, line := range d.b[bLo:bHi] {
+				d.ops = append(d.ops, diffOp{kind: '+', line: line})
+			}
+			break
+		}
+		d.diff(aLo, aLo+x, bLo, bLo+y)
+		for _, line := range d.a[aLo+x : aLo+u] {
+			d.ops = append(d.ops, diffOp{kind: ' ', line: line})
+		}
+		d.diff(aLo+u, aHi, bLo+v, bHi)
+	}
+	for _, line := range d.a[aHi : aHi+suffix] {
+		d.ops = append(d.ops, diffOp{kind: ' ', line: line})
+	}
+}
+
+// middleSnake returns the middle snake of a shortest edit turning a[aLo:aHi] into b[bLo:bHi]: the run of equal
+// lines from (x, y) to (u, v), relative to aLo and bLo, that splits the edit in two halves of about the same length.
+// It searches from both ends at once, keeping only the furthest point reached on each diagonal.
+// ok is false if the halves need more than maxDiffSteps steps each.
+func (d *differ) middleSnake(aLo int, aHi int, bLo int, bHi int) (x int, y int, u int, v int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	if maxD > maxDiffSteps {
+		maxD = maxDiffSteps
+	}
+	offset := maxD + 1
+	// forward[offset+k] is the furthest x reached from the start on diagonal k = x-y, and backward[offset+k]
+	// the furthest distance reached from the end on the reversed diagonal k, which is diagonal delta-k.
+	forward := make([]int, 2*maxD+3)
+	backward := make([]int, 2*maxD+3)
+	for steps := 0; steps <= maxD; steps++ {
+		for k := -steps; k <= steps; k += 2 {
+			x := forward[offset+k-1] + 1
+			if k == -steps || (k != steps && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x, y = x+1, y+1
+			}
+			forward[offset+k] = x
+			if reverseK := delta - k; odd && reverseK >= -(steps-1) && reverseK <= steps-1 && x+backward[offset+reverseK] >= n {
+				return startX, startY, x, y, true
+			}
+		}
+		for k := -steps; k <= steps; k += 2 {
+			x := backward[offset+k-1] + 1
+			if k == -steps || (k != steps && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x, y = x+1, y+1
+			}
+			backward[offset+k] = x
+			if forwardK := delta - k; !odd && forwardK >= -steps && forwardK <= steps && x+forward[offset+forwardK] >= n {
+				return n - x, m - y, n - startX, m - startY, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
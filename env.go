@@ -0,0 +1,76 @@
+package bincover
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvPolicy decides which variables of the test process's environment a binary starts from.
+// The env passed to RunBinary, and the variables set by the collector itself, are always added on top.
+type EnvPolicy int
+
+const (
+	// InheritEnv passes the test process's whole environment to the binary. This is the default.
+	InheritEnv EnvPolicy = iota
+	// MinimalEnv only passes PATH, HOME and TMPDIR, so that stray variables in a developer's shell or on a CI
+	// runner can't change the binary's behavior.
+	MinimalEnv
+	// EmptyEnv passes none of the test process's environment.
+	EmptyEnv
+)
+
+var minimalEnvKeys = []string{"PATH", "HOME", "TMPDIR"}
+
+// Env sets the policy deciding which variables of the test process's environment the binary inherits.
+func Env(policy EnvPolicy) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.envPolicy = policy
+	}
+}
+
+// baseEnv returns the part of the test process's environment policy lets through.
+func (policy EnvPolicy) baseEnv() []string {
+	switch policy {
+	case MinimalEnv:
+		var env []string
+		for _, key := range minimalEnvKeys {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+		return env
+	case EmptyEnv:
+		return nil
+	default:
+		return os.Environ()
+	}
+}
+
+// commandEnv returns the environment of a binary run with cfg: the base environment of cfg's policy,
+// followed by the sandbox's variables, env and GOCOVERDIR.
+func (cfg runConfig) commandEnv(sandbox *sandbox, env []string, coverDir string) []string {
+	return resolveEnv(cfg.envPolicy.baseEnv(), sandbox.env(), env, []string{goCoverDirEnvVar + "=" + coverDir})
+}
+
+// resolveEnv concatenates envs, keeping a single entry per key. The last value of a key wins,
+// and keys stay in the order they first appear in.
+func resolveEnv(envs ...[]string) []string {
+	// Never nil, since exec.Cmd treats a nil Env as the test process's environment.
+	resolved := []string{}
+	index := make(map[string]int)
+	for _, env := range envs {
+		for _, kv := range env {
+			key := kv
+			if i := strings.Index(kv, "="); i != -1 {
+				key = kv[:i]
+			}
+			if i, ok := index[key]; ok {
+				resolved[i] = kv
+				continue
+			}
+			index[key] = len(resolved)
+			resolved = append(resolved, kv)
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,98 @@
+package bincover
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		envs [][]string
+		want []string
+	}{
+		{
+			name: "succeed resolving empty environment",
+			want: []string{},
+		},
+		{
+			name: "succeed keeping last value of duplicate keys",
+			envs: [][]string{{"A=1", "B=2"}, {"C=3", "A=4"}, {"B=5"}},
+			want: []string{"A=4", "B=5", "C=3"},
+		},
+		{
+			name: "succeed resolving values containing equals signs",
+			envs: [][]string{{"A=x=y"}, {"A=z", "B="}},
+			want: []string{"A=z", "B="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, resolveEnv(tt.envs...))
+		})
+	}
+}
+
+func TestCoverageCollector_Run_envPolicy(t *testing.T) {
+	t.Setenv("BINCOVER_STRAY", "stray")
+	t.Setenv("HOME", t.TempDir())
+	tests := []struct {
+		name       string
+		options    []CoverageCollectorOption
+		env        []string
+		wantOutput string
+		wantEnv    []string
+		notWantEnv []string
+	}{
+		{
+			name:       "succeed inheriting environment by default",
+			env:        []string{"BINCOVER_CUSTOM=first", "BINCOVER_CUSTOM=last"},
+			wantOutput: "BINCOVER_STRAY=stray\nBINCOVER_CUSTOM=last\nHOME is set\n",
+			wantEnv:    []string{"BINCOVER_STRAY=stray", "BINCOVER_CUSTOM=last"},
+		},
+		{
+			name:       "succeed overriding inherited environment",
+			env:        []string{"BINCOVER_STRAY=overridden"},
+			wantOutput: "BINCOVER_STRAY=overridden\nBINCOVER_CUSTOM=unset\nHOME is set\n",
+			wantEnv:    []string{"BINCOVER_STRAY=overridden"},
+			notWantEnv: []string{"BINCOVER_STRAY=stray"},
+		},
+		{
+			name:       "succeed starting from minimal environment",
+			options:    []CoverageCollectorOption{Env(MinimalEnv)},
+			env:        []string{"BINCOVER_CUSTOM=custom"},
+			wantOutput: "BINCOVER_STRAY=unset\nBINCOVER_CUSTOM=custom\nHOME is set\n",
+			wantEnv:    []string{"BINCOVER_CUSTOM=custom"},
+			notWantEnv: []string{"BINCOVER_STRAY=stray"},
+		},
+		{
+			name:       "succeed starting from empty environment",
+			options:    []CoverageCollectorOption{Env(EmptyEnv)},
+			env:        []string{"BINCOVER_CUSTOM=custom"},
+			wantOutput: "BINCOVER_STRAY=unset\nBINCOVER_CUSTOM=custom\n",
+			wantEnv:    []string{"BINCOVER_CUSTOM=custom"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false, tt.options...)
+			require.NoError(t, c.Setup())
+			result, err := c.Run(context.Background(), "./test_bins/print_env.sh", "", tt.env, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOutput, result.Stdout)
+			require.Subset(t, result.Env, tt.wantEnv)
+			for _, kv := range tt.notWantEnv {
+				require.NotContains(t, result.Env, kv)
+			}
+			keys := make(map[string]bool)
+			for _, kv := range result.Env {
+				key := strings.SplitN(kv, "=", 2)[0]
+				require.False(t, keys[key], "duplicate key %s", key)
+				keys[key] = true
+			}
+		})
+	}
+}
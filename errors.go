@@ -0,0 +1,57 @@
+package bincover
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotSetUp is returned when a binary is run before Setup is called.
+	ErrNotSetUp = errors.New("RunBinary called before Setup")
+	// ErrMissingMetadata is returned when the output of a binary lacks the metadata RunTest prints, or the metadata
+	// can't be parsed. It usually means that the binary doesn't call RunTest, or that mainTestName is wrong.
+	ErrMissingMetadata = errors.New("missing bincover metadata")
+	// ErrInvalidCoverMode is returned when a binary reports an empty or unknown coverage mode. An empty mode means
+	// that the binary was built without coverage, while CollectCoverage is set.
+	ErrInvalidCoverMode = errors.New("invalid coverage mode")
+	// ErrCoverModeMismatch is returned when coverage with different coverage modes can't be merged,
+	// or can't be converted to the mode set with TargetCoverMode.
+	ErrCoverModeMismatch = errors.New("coverage mode mismatch")
+)
+
+// RunError is returned when the outcome of a run of a binary can't be interpreted. It wraps one of ErrMissingMetadata,
+// ErrInvalidCoverMode or ErrCoverModeMismatch, so errors.Is can tell them apart, and holds the raw output of the binary.
+type RunError struct {
+	BinPath string
+	// Output is the combined output of the binary, including any metadata printed by RunTest.
+	Output string
+	Err    error
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("command \"%s\": %s\nOutput:\n%s", e.BinPath, e.Err, e.Output)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// kindError is an error with its own message that errors.Is matches against one of the exported errors.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// errorOf returns an error formatted like errors.Errorf, for which errors.Is(err, kind) is true.
+func errorOf(kind error, format string, args ...interface{}) error {
+	return errors.WithStack(&kindError{kind: kind, msg: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,54 @@
+package bincover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunError(t *testing.T) {
+	tests := []struct {
+		name       string
+		binPath    string
+		wantErrIs  error
+		wantOutput string
+	}{
+		{
+			name:       "missing metadata",
+			binPath:    "./test_bins/no_metadata.sh",
+			wantErrIs:  ErrMissingMetadata,
+			wantOutput: "Hello world\n",
+		},
+		{
+			name:       "invalid coverage mode",
+			binPath:    "./test_bins/unexpected_covermode.sh",
+			wantErrIs:  ErrInvalidCoverMode,
+			wantOutput: "Hello world\n" + startOfMetadataMarker + "\n{\"cover_mode\":\"evil\",\"exit_code\":1}\n" + endOfMetadataMarker + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector(filepath.Join(t.TempDir(), "coverage.out"), true)
+			require.NoError(t, c.Setup())
+			_, _, err := c.RunBinary(tt.binPath, "", nil, nil)
+			require.ErrorIs(t, err, tt.wantErrIs)
+			var runErr *RunError
+			require.True(t, errors.As(err, &runErr))
+			require.Equal(t, tt.binPath, runErr.BinPath)
+			require.Equal(t, tt.wantOutput, runErr.Output)
+		})
+	}
+}
+
+func TestMerge_coverModeMismatch(t *testing.T) {
+	dir := t.TempDir()
+	set := filepath.Join(dir, "set.out")
+	bogus := filepath.Join(dir, "bogus.out")
+	require.NoError(t, os.WriteFile(set, []byte("mode: set\na.go:1.1,2.2 1 1\n"), 0644))
+	require.NoError(t, os.WriteFile(bogus, []byte("mode: bogus\na.go:1.1,2.2 1 1\n"), 0644))
+	err := Merge(filepath.Join(dir, "merged.out"), set, bogus)
+	require.ErrorIs(t, err, ErrCoverModeMismatch)
+}
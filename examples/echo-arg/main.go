@@ -9,7 +9,7 @@ import (
 )
 
 var (
-	// Injected from linker flags like `go build -ldflags "-X main.version=$VERSION" -X ...`
+	// Injected from linker flags like `go build -ldflags "-X main.isTest=true"`
 	isTest = "false"
 )
 
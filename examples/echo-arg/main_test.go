@@ -2,9 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"os/exec"
 	"regexp"
 	"testing"
 
@@ -14,13 +11,11 @@ import (
 )
 
 func TestMainMethod(t *testing.T) {
-	const binPath = "./instr_bin"
-	buildTestCmd := exec.Command("./build_instr_bin.sh")
-	output, err := buildTestCmd.CombinedOutput()
-	if err != nil {
-		log.Println(output)
-		panic(err)
-	}
+	binPath, err := bincover.Build(bincover.BuildOptions{
+		Ldflags:            "-X main.isTest=true",
+		GenerateEntrypoint: true,
+	})
+	require.NoError(t, err)
 	collector := bincover.NewCoverageCollector("echo_arg_coverage.out", true)
 	err = collector.Setup()
 	require.NoError(t, err)
@@ -29,10 +24,6 @@ func TestMainMethod(t *testing.T) {
 		if err != nil {
 			panic(err)
 		}
-		err = os.Remove(binPath)
-		if err != nil {
-			panic(err)
-		}
 	}()
 	tests := []struct {
 		name          string
@@ -55,15 +46,21 @@ func TestMainMethod(t *testing.T) {
 			wantExitCode:  1,
 		},
 		{
-			name:         "fail running main with no args",
+			name:         "succeed running main with an empty arg",
 			args:         []string{""},
+			wantOutput:   "Your argument is \"\"\n",
+			wantExitCode: 0,
+		},
+		{
+			name:         "fail running main with no args",
+			args:         nil,
 			wantOutput:   "Please provide an argument\n",
 			wantExitCode: 1,
 		},
 	}
 	for _, tt := range tests {
 		fmt.Println(tt.name)
-		output, exitCode, err := collector.RunBinary(binPath, "TestBincoverRunMain", []string{}, tt.args)
+		output, exitCode, err := collector.RunBinary(binPath, "", []string{}, tt.args)
 		require.NoError(t, err)
 		if tt.outputPattern != nil {
 			require.Regexp(t, tt.outputPattern, output)
@@ -0,0 +1,189 @@
+package bincover
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultTerminationGracePeriod = 5 * time.Second
+
+// TimeoutError is returned by RunBinaryContext when its context ends before the binary exits.
+// It wraps the context's error, so errors.Is(err, context.DeadlineExceeded) can be used to detect timeouts.
+type TimeoutError struct {
+	BinPath string
+	// Output is the combined output the binary wrote before it was stopped.
+	Output string
+	Err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("command \"%s\" stopped before exiting: %s\nOutput:\n%s", e.BinPath, e.Err, e.Output)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// outputCapture collects the stdout and stderr of a process, remembering the order in which they were written.
+// It is safe to read while the process writing to it is still running.
+type outputCapture struct {
+	mu     sync.Mutex
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	chunks []outputChunk
+}
+
+// outputChunk records the size of a single write to stdout or stderr.
+type outputChunk struct {
+	stderr bool
+	size   int
+}
+
+type streamWriter struct {
+	capture *outputCapture
+	stderr  bool
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.capture.mu.Lock()
+	defer w.capture.mu.Unlock()
+	w.capture.chunks = append(w.capture.chunks, outputChunk{stderr: w.stderr, size: len(p)})
+	if w.stderr {
+		return w.capture.stderr.Write(p)
+	}
+	return w.capture.stdout.Write(p)
+}
+
+func (o *outputCapture) snapshot() *capturedOutput {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return &capturedOutput{
+		stdout: o.stdout.String(),
+		stderr: o.stderr.String(),
+		chunks: append([]outputChunk(nil), o.chunks...),
+	}
+}
+
+// capturedOutput is the output of a process that has exited.
+type capturedOutput struct {
+	stdout string
+	stderr string
+	chunks []outputChunk
+	// duration is how long the process ran.
+	duration time.Duration
+}
+
+// interleave returns stdout and stderr interleaved in the order they were written,
+// keeping only the first stdoutLen bytes of stdout and the first stderrLen bytes of stderr.
+func (o *capturedOutput) interleave(stdoutLen int, stderrLen int) string {
+	var b strings.Builder
+	var stdoutPos, stderrPos int
+	for _, chunk := range o.chunks {
+		stream, pos, limit := o.stdout, &stdoutPos, stdoutLen
+		if chunk.stderr {
+			stream, pos, limit = o.stderr, &stderrPos, stderrLen
+		}
+		start, end := *pos, *pos+chunk.size
+		*pos = end
+		if end > limit {
+			end = limit
+		}
+		if start < end {
+			b.WriteString(stream[start:end])
+		}
+	}
+	return b.String()
+}
+
+func (o *capturedOutput) combined() string {
+	return o.interleave(len(o.stdout), len(o.stderr))
+}
+
+// result returns the captured output as a RunResult.
+func (o *capturedOutput) result() *RunResult {
+	return &RunResult{
+		Stdout:   o.stdout,
+		Stderr:   o.stderr,
+		Combined: o.combined(),
+		Duration: o.duration,
+	}
+}
+
+// runCmd runs cmd and returns its output. If ctx ends before cmd exits, cmd is sent SIGTERM and,
+// if it is still running after gracePeriod, SIGKILL. In that case runCmd returns a *TimeoutError with the
+// output captured so far. If combineOutput is set, stdout and stderr share a single pipe, so their output is kept in
+// the exact order it was written, all of it as stdout. Otherwise each stream has its own pipe, and the order
+// between them is only as good as the order in which the pipes are read.
+func runCmd(ctx context.Context, cmd *exec.Cmd, gracePeriod time.Duration, combineOutput bool) (*capturedOutput, error) {
+	if cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if cmd.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var output outputCapture
+	cmd.Stdout = streamWriter{capture: &output}
+	// exec.Cmd shares a single pipe between Stdout and Stderr only if they are equal.
+	cmd.Stderr = streamWriter{capture: &output, stderr: !combineOutput}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- cmd.Wait()
+	}()
+	select {
+	case err := <-waitDone:
+		captured := output.snapshot()
+		captured.duration = time.Since(start)
+		return captured, err
+	case <-ctx.Done():
+	}
+	terminate(cmd, waitDone, gracePeriod)
+	return nil, &TimeoutError{BinPath: cmd.Path, Output: output.snapshot().combined(), Err: ctx.Err()}
+}
+
+// terminate stops cmd with SIGTERM, followed by SIGKILL if cmd is still running after gracePeriod.
+// Platforms without SIGTERM support are sent SIGKILL right away.
+func terminate(cmd *exec.Cmd, waitDone <-chan error, gracePeriod time.Duration) {
+	gracePeriod = gracePeriodOrDefault(gracePeriod)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err == nil {
+		select {
+		case <-waitDone:
+			return
+		case <-time.After(gracePeriod):
+		}
+	}
+	_ = cmd.Process.Kill()
+	// Wait does not return until every process holding the output pipes exits. Don't let orphaned
+	// grandchildren block the caller forever.
+	select {
+	case <-waitDone:
+	case <-time.After(gracePeriod):
+	}
+}
+
+// exitSignal returns the signal that killed the process of exitError, or nil if the process exited by itself.
+func exitSignal(exitError *exec.ExitError) os.Signal {
+	if status, ok := exitError.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal()
+	}
+	return nil
+}
+
+func gracePeriodOrDefault(gracePeriod time.Duration) time.Duration {
+	if gracePeriod <= 0 {
+		return defaultTerminationGracePeriod
+	}
+	return gracePeriod
+}
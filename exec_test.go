@@ -0,0 +1,111 @@
+package bincover
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runCmd(t *testing.T) {
+	tests := []struct {
+		name        string
+		binPath     string
+		timeout     time.Duration
+		gracePeriod time.Duration
+		wantOutput  string
+		wantTimeout bool
+		maxDuration time.Duration
+	}{
+		{
+			name:        "succeed running command before timeout",
+			binPath:     "./test_bins/exit_1.sh",
+			timeout:     5 * time.Second,
+			wantOutput:  helloWorldOutput,
+			maxDuration: 5 * time.Second,
+		},
+		{
+			name:        "stop command with SIGTERM when context ends",
+			binPath:     "./test_bins/sleep.sh",
+			timeout:     200 * time.Millisecond,
+			gracePeriod: 5 * time.Second,
+			wantOutput:  "Going to sleep\n",
+			wantTimeout: true,
+			maxDuration: 3 * time.Second,
+		},
+		{
+			name:        "stop command ignoring SIGTERM with SIGKILL after grace period",
+			binPath:     "./test_bins/ignore_sigterm.sh",
+			timeout:     200 * time.Millisecond,
+			gracePeriod: 200 * time.Millisecond,
+			wantOutput:  "Ignoring SIGTERM\n",
+			wantTimeout: true,
+			maxDuration: 3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			output, err := runCmd(ctx, exec.Command(tt.binPath), tt.gracePeriod, true)
+			require.Less(t, time.Since(start), tt.maxDuration)
+			if !tt.wantTimeout {
+				require.Equal(t, tt.wantOutput, output.combined())
+				return
+			}
+			var timeoutErr *TimeoutError
+			require.True(t, errors.As(err, &timeoutErr))
+			require.True(t, errors.Is(err, context.DeadlineExceeded))
+			require.Equal(t, tt.wantOutput, timeoutErr.Output)
+			require.Equal(t, tt.binPath, timeoutErr.BinPath)
+		})
+	}
+}
+
+func Test_capturedOutput_interleave(t *testing.T) {
+	output := &capturedOutput{
+		stdout: "out1\nMETA\nout2\n",
+		stderr: "err1\nerr2\n",
+		chunks: []outputChunk{
+			{size: 5},
+			{stderr: true, size: 5},
+			{size: 5},
+			{stderr: true, size: 5},
+			{size: 5},
+		},
+	}
+	tests := []struct {
+		name      string
+		stdoutLen int
+		stderrLen int
+		want      string
+	}{
+		{
+			name:      "succeed interleaving full streams",
+			stdoutLen: len(output.stdout),
+			stderrLen: len(output.stderr),
+			want:      "out1\nerr1\nMETA\nerr2\nout2\n",
+		},
+		{
+			name:      "succeed interleaving truncated stdout",
+			stdoutLen: 5,
+			stderrLen: len(output.stderr),
+			want:      "out1\nerr1\nerr2\n",
+		},
+		{
+			name:      "succeed interleaving stream truncated within a chunk",
+			stdoutLen: len(output.stdout),
+			stderrLen: 2,
+			want:      "out1\nerMETA\nout2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, output.interleave(tt.stdoutLen, tt.stderrLen))
+		})
+	}
+}
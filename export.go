@@ -0,0 +1,287 @@
+package bincover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const coberturaDocType = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+// WriteCoberturaReport writes the merged coverage profile to filename as Cobertura XML.
+// It must be called after TearDown. See CoberturaReport.
+func (c *CoverageCollector) WriteCoberturaReport(filename string) error {
+	var buf bytes.Buffer
+	if err := CoberturaReport(c.MergedCoverageFilename, &buf); err != nil {
+		return err
+	}
+	return errors.Wrap(os.WriteFile(filename, buf.Bytes(), 0644), "error writing Cobertura report")
+}
+
+// WriteLCOVReport writes the merged coverage profile to filename as an LCOV tracefile.
+// It must be called after TearDown. See LCOVReport.
+func (c *CoverageCollector) WriteLCOVReport(filename string) error {
+	var buf bytes.Buffer
+	if err := LCOVReport(c.MergedCoverageFilename, &buf); err != nil {
+		return err
+	}
+	return errors.Wrap(os.WriteFile(filename, buf.Bytes(), 0644), "error writing LCOV report")
+}
+
+// CoberturaReport writes the coverage profile at profileFilename to w as Cobertura XML.
+// Every line spanned by a block is a line of the report, hit as often as the most executed block spanning it.
+// Go profiles have no branch data, so blocks stand in for branches: the branch rates are the rates of executed
+// blocks, and lines spanned by several blocks are reported as branches with their block coverage.
+// File names are relative to the root of the current directory's module, found through its go.mod,
+// which is reported as the only source. Files outside the module keep their import path.
+func CoberturaReport(profileFilename string, w io.Writer) error {
+	p, err := readProfileFile(profileFilename)
+	if err != nil {
+		return err
+	}
+	resolver, err := newSourceResolver(".")
+	if err != nil {
+		return err
+	}
+	return writeCobertura(w, p, resolver, time.Now())
+}
+
+// LCOVReport writes the coverage profile at profileFilename to w as an LCOV tracefile.
+// Lines are reported like in CoberturaReport, and every block is reported as a branch (BRDA) on its first line.
+// File names are resolved like in CoberturaReport.
+func LCOVReport(profileFilename string, w io.Writer) error {
+	p, err := readProfileFile(profileFilename)
+	if err != nil {
+		return err
+	}
+	resolver, err := newSourceResolver(".")
+	if err != nil {
+		return err
+	}
+	return writeLCOV(w, p, resolver)
+}
+
+// exportFile is the coverage of a single file, in the terms of line-based report formats.
+type exportFile struct {
+	// name is the file's path relative to the module root, or its import path if it is outside the module.
+	name   string
+	pkg    string
+	blocks []*profileBlock
+	// lineNumbers are the numbers of the lines spanned by blocks, in increasing order.
+	lineNumbers []int
+	lines       map[int]*lineCoverage
+}
+
+// exportRates counts the lines and blocks of a file, package or profile, and how many of them were executed.
+type exportRates struct {
+	lines, linesHit, blocks, blocksHit int
+}
+
+func (r *exportRates) add(other exportRates) {
+	r.lines += other.lines
+	r.linesHit += other.linesHit
+	r.blocks += other.blocks
+	r.blocksHit += other.blocksHit
+}
+
+func (r exportRates) lineRate() string {
+	return formatRate(r.linesHit, r.lines)
+}
+
+func (r exportRates) blockRate() string {
+	return formatRate(r.blocksHit, r.blocks)
+}
+
+func formatRate(hit int, total int) string {
+	if total == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(hit)/float64(total), 'f', 4, 64)
+}
+
+// exportFiles returns the coverage of every file in p, ordered by name.
+func exportFiles(p *profile, resolver *sourceResolver) ([]*exportFile, error) {
+	var files []*exportFile
+	for _, name := range p.sortedFiles() {
+		blocks, err := p.sortedBlocks(name)
+		if err != nil {
+			return nil, err
+		}
+		file := &exportFile{name: name, pkg: path.Dir(name), blocks: blocks, lines: lineCoverages(blocks)}
+		if rel, ok := resolver.moduleRelPath(name); ok {
+			file.name = rel
+		}
+		for line := range file.lines {
+			file.lineNumbers = append(file.lineNumbers, line)
+		}
+		sort.Ints(file.lineNumbers)
+		files = append(files, file)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+func (f *exportFile) rates() exportRates {
+	var r exportRates
+	for _, lc := range f.lines {
+		r.lines++
+		if lc.Count > 0 {
+			r.linesHit++
+		}
+	}
+	for _, block := range f.blocks {
+		if block.NumStmt == 0 {
+			continue
+		}
+		r.blocks++
+		if block.Count > 0 {
+			r.blocksHit++
+		}
+	}
+	return r
+}
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int    `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+func writeCobertura(w io.Writer, p *profile, resolver *sourceResolver, now time.Time) error {
+	files, err := exportFiles(p, resolver)
+	if err != nil {
+		return err
+	}
+	coverage := coberturaCoverage{Version: "bincover", Timestamp: now.UnixMilli()}
+	if resolver.moduleDir != "" {
+		coverage.Sources = []string{resolver.moduleDir}
+	}
+	var total exportRates
+	pkgRates := make(map[string]*exportRates)
+	pkgIndex := make(map[string]int)
+	for _, file := range files {
+		rates := file.rates()
+		class := coberturaClass{
+			Name:       path.Base(file.name),
+			Filename:   file.name,
+			LineRate:   rates.lineRate(),
+			BranchRate: rates.blockRate(),
+		}
+		for _, number := range file.lineNumbers {
+			lc := file.lines[number]
+			line := coberturaLine{Number: number, Hits: lc.Count}
+			if lc.Blocks > 1 {
+				line.Branch = true
+				line.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", 100*lc.BlocksHit/lc.Blocks, lc.BlocksHit, lc.Blocks)
+			}
+			class.Lines = append(class.Lines, line)
+		}
+		i, ok := pkgIndex[file.pkg]
+		if !ok {
+			i = len(coverage.Packages)
+			pkgIndex[file.pkg] = i
+			pkgRates[file.pkg] = &exportRates{}
+			coverage.Packages = append(coverage.Packages, coberturaPackage{Name: file.pkg})
+		}
+		coverage.Packages[i].Classes = append(coverage.Packages[i].Classes, class)
+		pkgRates[file.pkg].add(rates)
+		total.add(rates)
+	}
+	for i := range coverage.Packages {
+		rates := pkgRates[coverage.Packages[i].Name]
+		coverage.Packages[i].LineRate, coverage.Packages[i].BranchRate = rates.lineRate(), rates.blockRate()
+	}
+	sort.Slice(coverage.Packages, func(i, j int) bool {
+		return coverage.Packages[i].Name < coverage.Packages[j].Name
+	})
+	coverage.LineRate, coverage.BranchRate = total.lineRate(), total.blockRate()
+	coverage.LinesCovered, coverage.LinesValid = total.linesHit, total.lines
+	coverage.BranchesCovered, coverage.BranchesValid = total.blocksHit, total.blocks
+
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "%s%s\n", xml.Header, coberturaDocType); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(bw)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(coverage); err != nil {
+		return errors.Wrap(err, "error encoding Cobertura report")
+	}
+	if _, err := bw.WriteString("\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeLCOV(w io.Writer, p *profile, resolver *sourceResolver) error {
+	files, err := exportFiles(p, resolver)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, file := range files {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", file.name)
+		for i, block := range file.blocks {
+			if block.NumStmt == 0 {
+				continue
+			}
+			taken := "-"
+			if block.Count > 0 {
+				taken = strconv.Itoa(block.Count)
+			}
+			fmt.Fprintf(bw, "BRDA:%d,0,%d,%s\n", block.StartLine, i, taken)
+		}
+		rates := file.rates()
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", rates.blocks, rates.blocksHit)
+		for _, number := range file.lineNumbers {
+			fmt.Fprintf(bw, "DA:%d,%d\n", number, file.lines[number].Count)
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", rates.lines, rates.linesHit)
+	}
+	return bw.Flush()
+}
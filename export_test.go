@@ -0,0 +1,116 @@
+package bincover
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const exportTestProfile = `mode: count
+github.com/confluentinc/bincover/test_bins/set_covermode.go:9.13,11.22 2 4
+github.com/confluentinc/bincover/test_bins/set_covermode.go:11.22,12.2 1 0
+example.com/other/pkg/file.go:1.1,2.2 3 0
+`
+
+func parseExportTestProfile(t *testing.T) (*profile, *sourceResolver) {
+	p, err := parseProfile(strings.NewReader(exportTestProfile))
+	require.NoError(t, err)
+	resolver, err := newSourceResolver(".")
+	require.NoError(t, err)
+	return p, resolver
+}
+
+func Test_writeCobertura(t *testing.T) {
+	p, resolver := parseExportTestProfile(t)
+	var buf bytes.Buffer
+	require.NoError(t, writeCobertura(&buf, p, resolver, time.UnixMilli(1700000000000)))
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">
+<coverage line-rate="0.5000" branch-rate="0.3333" lines-covered="3" lines-valid="6" branches-covered="1" branches-valid="3" complexity="0" version="bincover" timestamp="1700000000000">
+  <sources>
+    <source>` + resolver.moduleDir + `</source>
+  </sources>
+  <packages>
+    <package name="example.com/other/pkg" line-rate="0.0000" branch-rate="0.0000" complexity="0">
+      <classes>
+        <class name="file.go" filename="example.com/other/pkg/file.go" line-rate="0.0000" branch-rate="0.0000" complexity="0">
+          <methods></methods>
+          <lines>
+            <line number="1" hits="0" branch="false"></line>
+            <line number="2" hits="0" branch="false"></line>
+          </lines>
+        </class>
+      </classes>
+    </package>
+    <package name="github.com/confluentinc/bincover/test_bins" line-rate="0.7500" branch-rate="0.5000" complexity="0">
+      <classes>
+        <class name="set_covermode.go" filename="test_bins/set_covermode.go" line-rate="0.7500" branch-rate="0.5000" complexity="0">
+          <methods></methods>
+          <lines>
+            <line number="9" hits="4" branch="false"></line>
+            <line number="10" hits="4" branch="false"></line>
+            <line number="11" hits="4" branch="true" condition-coverage="50% (1/2)"></line>
+            <line number="12" hits="0" branch="false"></line>
+          </lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>
+`
+	require.Equal(t, want, buf.String())
+}
+
+func Test_writeLCOV(t *testing.T) {
+	p, resolver := parseExportTestProfile(t)
+	var buf bytes.Buffer
+	require.NoError(t, writeLCOV(&buf, p, resolver))
+	want := `TN:
+SF:example.com/other/pkg/file.go
+BRDA:1,0,0,-
+BRF:1
+BRH:0
+DA:1,0
+DA:2,0
+LF:2
+LH:0
+end_of_record
+TN:
+SF:test_bins/set_covermode.go
+BRDA:9,0,0,4
+BRDA:11,0,1,-
+BRF:2
+BRH:1
+DA:9,4
+DA:10,4
+DA:11,4
+DA:12,0
+LF:4
+LH:3
+end_of_record
+`
+	require.Equal(t, want, buf.String())
+}
+
+func Test_newSourceResolver(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("// A module.\nmodule example.com/mod // comment\n\ngo 1.19\n"), 0600))
+	dir := filepath.Join(root, "sub", "dir")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	resolver, err := newSourceResolver(dir)
+	require.NoError(t, err)
+	require.Equal(t, "example.com/mod", resolver.modulePath)
+	rel, ok := resolver.moduleRelPath("example.com/mod/sub/file.go")
+	require.True(t, ok)
+	require.Equal(t, "sub/file.go", rel)
+	_, ok = resolver.moduleRelPath("example.com/module/file.go")
+	require.False(t, ok)
+	path, ok := resolver.resolve("example.com/mod/file.go")
+	require.True(t, ok)
+	require.Equal(t, filepath.Join(root, "file.go"), path)
+}
@@ -0,0 +1,155 @@
+package bincover
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	goldenDir = "testdata"
+	// updateGoldenEnvVar rewrites golden files when set to a true value, such as 1, for test binaries
+	// that don't define an -update flag.
+	updateGoldenEnvVar = "BINCOVER_UPDATE_GOLDEN"
+	updateGoldenFlag   = "update"
+)
+
+// Normalizer rewrites output before it is compared to a golden file, typically to replace the parts that change
+// from run to run with a fixed placeholder.
+type Normalizer func(output string) string
+
+var (
+	timestampRegexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+	uuidRegexp      = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+)
+
+// ScrubTimestamps replaces RFC 3339 timestamps, and timestamps with a space instead of the "T", with "<TIMESTAMP>".
+func ScrubTimestamps(output string) string {
+	return timestampRegexp.ReplaceAllString(output, "<TIMESTAMP>")
+}
+
+// ScrubUUIDs replaces UUIDs with "<UUID>".
+func ScrubUUIDs(output string) string {
+	return uuidRegexp.ReplaceAllString(output, "<UUID>")
+}
+
+// ScrubTempPaths replaces paths inside the temporary directory, such as those of t.TempDir and Sandbox, with "<TMP>".
+// The temporary directory is looked up once, on the first call.
+func ScrubTempPaths(output string) string {
+	tempPathRegexpsOnce.Do(compileTempPathRegexps)
+	for _, re := range tempPathRegexps {
+		output = re.ReplaceAllString(output, "<TMP>")
+	}
+	return output
+}
+
+var (
+	tempPathRegexpsOnce sync.Once
+	// tempPathRegexps match paths inside os.TempDir, both as named and with its symlinks resolved.
+	tempPathRegexps []*regexp.Regexp
+)
+
+func compileTempPathRegexps() {
+	tmpDirs := []string{os.TempDir()}
+	// Binaries that resolve their working directory see the temporary directory without symlinks.
+	if resolved, err := filepath.EvalSymlinks(os.TempDir()); err == nil && resolved != os.TempDir() {
+		tmpDirs = append(tmpDirs, resolved)
+	}
+	for _, dir := range tmpDirs {
+		re := regexp.MustCompile(regexp.QuoteMeta(filepath.Clean(dir)) + `(` + regexp.QuoteMeta(string(filepath.Separator)) + "[^\\s\"'`]*)?")
+		tempPathRegexps = append(tempPathRegexps, re)
+	}
+}
+
+// ReplaceString returns a Normalizer replacing every occurrence of old with replacement.
+func ReplaceString(old string, replacement string) Normalizer {
+	return func(output string) string {
+		return strings.ReplaceAll(output, old, replacement)
+	}
+}
+
+// ReplaceRegexp returns a Normalizer replacing every match of re with replacement, which may refer to submatches
+// like regexp.Regexp.ReplaceAllString.
+func ReplaceRegexp(re *regexp.Regexp, replacement string) Normalizer {
+	return func(output string) string {
+		return re.ReplaceAllString(output, replacement)
+	}
+}
+
+// AssertGolden compares output, typically returned by RunBinary, to the golden file testdata/<test name>.golden,
+// after applying normalizers to it in order. On a mismatch, the test fails with a unified diff of the golden file
+// and the normalized output.
+// The golden file is rewritten with the normalized output instead if BINCOVER_UPDATE_GOLDEN is set to a true value,
+// or if the -update flag registered by RegisterUpdateFlag, or a boolean -update flag defined by the test package
+// itself, is set.
+func AssertGolden(t testing.TB, output string, normalizers ...Normalizer) {
+	t.Helper()
+	if err := checkGolden(GoldenPath(t), output, shouldUpdateGolden(flag.CommandLine), normalizers...); err != nil {
+		t.Error(err)
+	}
+}
+
+// RegisterUpdateFlag defines the boolean -update flag that makes AssertGolden rewrite golden files. Call it from an
+// init function or TestMain of the test package, before the flags are parsed. bincover doesn't define the flag on
+// import, so that it can't clash with the flags of the packages importing it; if the test package already defines
+// -update, RegisterUpdateFlag leaves it as is.
+func RegisterUpdateFlag() {
+	registerUpdateFlag(flag.CommandLine)
+}
+
+func registerUpdateFlag(flags *flag.FlagSet) {
+	if flags.Lookup(updateGoldenFlag) == nil {
+		flags.Bool(updateGoldenFlag, false, "rewrite the golden files compared by bincover.AssertGolden")
+	}
+}
+
+// shouldUpdateGolden reports whether golden files should be rewritten: if flags has a boolean "update" flag that is
+// set, or if BINCOVER_UPDATE_GOLDEN is set to a true value.
+func shouldUpdateGolden(flags *flag.FlagSet) bool {
+	if f := flags.Lookup(updateGoldenFlag); f != nil {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if update, ok := getter.Get().(bool); ok && update {
+				return true
+			}
+		}
+	}
+	update, _ := strconv.ParseBool(os.Getenv(updateGoldenEnvVar))
+	return update
+}
+
+// GoldenPath returns the path of the golden file of t: testdata/<test name>.golden.
+// Subtests are stored in a directory named after their parent test.
+func GoldenPath(t testing.TB) string {
+	return filepath.Join(goldenDir, filepath.FromSlash(t.Name())+".golden")
+}
+
+// checkGolden compares the normalized output to the golden file at path, or writes it to path if update is set.
+func checkGolden(path string, output string, update bool, normalizers ...Normalizer) error {
+	for _, normalize := range normalizers {
+		output = normalize(output)
+	}
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return errors.Wrap(err, "error creating golden file directory")
+		}
+		return errors.Wrap(os.WriteFile(path, []byte(output), 0644), "error writing golden file")
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("golden file %s does not exist, run the test with -update or %s=1 to create it", path, updateGoldenEnvVar)
+		}
+		return errors.Wrap(err, "error reading golden file")
+	}
+	if diff := unifiedDiff(path, "output", string(want), output); diff != "" {
+		return errors.Errorf("output does not match golden file %s, run the test with -update or %s=1 to rewrite it:\n%s", path, updateGoldenEnvVar, diff)
+	}
+	return nil
+}
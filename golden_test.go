@@ -0,0 +1,252 @@
+package bincover
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertGolden(t *testing.T) {
+	c := NewCoverageCollector("", false)
+	require.NoError(t, c.Setup())
+	args := []string{"created", "3f2b8c1e-9a4d-4e5f-8b6a-1c2d3e4f5a6b", "at", "2024-01-02T03:04:05.123Z", "in", filepath.Join(t.TempDir(), "config.json")}
+	output, _, err := c.RunBinary("./test_bins/script_bin.sh", "", nil, args)
+	require.NoError(t, err)
+	AssertGolden(t, output, ScrubUUIDs, ScrubTimestamps, ScrubTempPaths)
+}
+
+func Test_checkGolden(t *testing.T) {
+	tests := []struct {
+		name        string
+		golden      string
+		output      string
+		update      bool
+		normalizers []Normalizer
+		wantGolden  string
+		errMessage  string
+	}{
+		{
+			name:       "succeed matching golden file",
+			golden:     "Hello world\n",
+			output:     "Hello world\n",
+			wantGolden: "Hello world\n",
+		},
+		{
+			name:        "succeed matching normalized output",
+			golden:      "Hello <NAME>\n",
+			output:      "Hello world\n",
+			normalizers: []Normalizer{ReplaceString("world", "<NAME>")},
+			wantGolden:  "Hello <NAME>\n",
+		},
+		{
+			name:       "fail with a diff on mismatch",
+			golden:     "Hello world\n",
+			output:     "Hello moon\n",
+			wantGolden: "Hello world\n",
+			errMessage: "output does not match golden file <golden>, run the test with -update or BINCOVER_UPDATE_GOLDEN=1 to rewrite it:\n" +
+				"--- <golden>\n+++ output\n@@ -1 +1 @@\n-Hello world\n+Hello moon\n",
+		},
+		{
+			name:       "fail without golden file",
+			output:     "Hello world\n",
+			errMessage: "golden file <golden> does not exist, run the test with -update or BINCOVER_UPDATE_GOLDEN=1 to create it",
+		},
+		{
+			name:        "succeed rewriting golden file on update",
+			golden:      "Hello world\n",
+			output:      "Hello moon 2024-01-02 03:04:05\n",
+			update:      true,
+			normalizers: []Normalizer{ScrubTimestamps},
+			wantGolden:  "Hello moon <TIMESTAMP>\n",
+		},
+		{
+			name:       "succeed creating golden file on update",
+			output:     "Hello world\n",
+			update:     true,
+			wantGolden: "Hello world\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "testdata", "TestName.golden")
+			if tt.golden != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(tt.golden), 0644))
+			}
+			err := checkGolden(path, tt.output, tt.update, tt.normalizers...)
+			if tt.errMessage != "" {
+				require.EqualError(t, err, regexp.MustCompile("<golden>").ReplaceAllLiteralString(tt.errMessage, path))
+				return
+			}
+			require.NoError(t, err)
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantGolden, string(got))
+		})
+	}
+}
+
+func Test_shouldUpdateGolden(t *testing.T) {
+	tests := []struct {
+		name       string
+		updateFlag string
+		envVar     string
+		want       bool
+	}{
+		{
+			name: "succeed comparing without flag or env var",
+		},
+		{
+			name:       "succeed comparing with unset flag",
+			updateFlag: "false",
+		},
+		{
+			name:       "succeed updating with set flag",
+			updateFlag: "true",
+			want:       true,
+		},
+		{
+			name:   "succeed updating with env var",
+			envVar: "1",
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(updateGoldenEnvVar, tt.envVar)
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			if tt.updateFlag != "" {
+				flags.Bool("update", false, "")
+				require.NoError(t, flags.Set("update", tt.updateFlag))
+			}
+			require.Equal(t, tt.want, shouldUpdateGolden(flags))
+		})
+	}
+}
+
+func Test_registerUpdateFlag(t *testing.T) {
+	t.Setenv(updateGoldenEnvVar, "")
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerUpdateFlag(flags)
+	require.False(t, shouldUpdateGolden(flags))
+	require.NoError(t, flags.Parse([]string{"-update"}))
+	require.True(t, shouldUpdateGolden(flags))
+
+	// An -update flag already defined by the test package is kept.
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	update := flags.Bool("update", false, "")
+	registerUpdateFlag(flags)
+	require.NoError(t, flags.Parse([]string{"-update"}))
+	require.True(t, *update)
+}
+
+func TestNormalizers(t *testing.T) {
+	tmpDir := t.TempDir()
+	tests := []struct {
+		name       string
+		normalizer Normalizer
+		output     string
+		want       string
+	}{
+		{
+			name:       "scrub timestamps",
+			normalizer: ScrubTimestamps,
+			output:     "at 2024-01-02T03:04:05Z, 2024-01-02 03:04:05.999 and 2024-01-02T03:04:05+01:00\n",
+			want:       "at <TIMESTAMP>, <TIMESTAMP> and <TIMESTAMP>\n",
+		},
+		{
+			name:       "scrub UUIDs",
+			normalizer: ScrubUUIDs,
+			output:     "id 3F2B8C1E-9A4D-4E5F-8B6A-1C2D3E4F5A6B\n",
+			want:       "id <UUID>\n",
+		},
+		{
+			name:       "scrub temp paths",
+			normalizer: ScrubTempPaths,
+			output:     "wrote \"" + filepath.Join(tmpDir, "a b.txt") + "\" to " + tmpDir + "\n",
+			want:       "wrote \"<TMP> b.txt\" to <TMP>\n",
+		},
+		{
+			name:       "replace regexp",
+			normalizer: ReplaceRegexp(regexp.MustCompile(`took \d+ms`), "took <DURATION>"),
+			output:     "took 15ms\n",
+			want:       "took <DURATION>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.normalizer(tt.output))
+		})
+	}
+}
+
+func Test_unifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{
+			name:    "equal texts",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			want:    "",
+		},
+		{
+			name:    "change with context",
+			oldText: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			newText: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want:    "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:    "separate hunks",
+			oldText: "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			newText: "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			want:    "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name:    "insert into empty text",
+			oldText: "",
+			newText: "a\n",
+			want:    "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:    "missing final newline",
+			oldText: "a\n",
+			newText: "a",
+			want:    "--- old\n+++ new\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, unifiedDiff("old", "new", tt.oldText, tt.newText))
+		})
+	}
+}
+
+func Test_diffLines_largeTexts(t *testing.T) {
+	var oldText, newText, otherText strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&oldText, "line %d\n", i)
+		fmt.Fprintf(&otherText, "other %d\n", i)
+		if i == 25000 {
+			newText.WriteString("changed\n")
+		} else {
+			fmt.Fprintf(&newText, "line %d\n", i)
+		}
+	}
+	want := "--- old\n+++ new\n@@ -24998,7 +24998,7 @@\n line 24997\n line 24998\n line 24999\n-line 25000\n+changed\n line 25001\n line 25002\n line 25003\n"
+	require.Equal(t, want, unifiedDiff("old", "new", oldText.String(), newText.String()))
+	// Texts without a line in common are too different to diff exactly, so every line is removed, then added.
+	ops := diffLines(splitLines(oldText.String()), splitLines(otherText.String()))
+	require.Len(t, ops, 100000)
+	require.Equal(t, diffOp{kind: '-', line: "line 0\n"}, ops[0])
+	require.Equal(t, diffOp{kind: '+', line: "other 0\n"}, ops[50000])
+}
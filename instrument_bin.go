@@ -11,13 +11,17 @@ import (
 )
 
 var (
-	argsFilename = flag.String("args-file", "", "custom args file, newline separated")
-	ExitCode     = 0
+	argsFilename     = flag.String("args-file", "", "custom args file, each arg terminated by a NUL byte")
+	metadataFilename = flag.String("metadata-file", "", "file to write bincover metadata to, instead of printing it to stdout")
+	ExitCode         = 0
 )
 
 const (
 	startOfMetadataMarker = "START_BINCOVER_METADATA"
 	endOfMetadataMarker   = "END_BINCOVER_METADATA"
+	// argTerminator ends every arg in an args file. An arg can't contain a NUL byte, so unlike newlines,
+	// it lets args with newlines, surrounding spaces or no content at all through unchanged.
+	argTerminator = "\x00"
 )
 
 func parseCustomArgs() ([]string, error) {
@@ -25,20 +29,56 @@ func parseCustomArgs() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	rawArgs := strings.Split(string(buf), "\n")
-	var parsedCustomArgs []string
-	for _, arg := range rawArgs {
+	return decodeArgs(string(buf)), nil
+}
+
+// encodeArgs encodes args for an args file, terminating each of them with argTerminator.
+func encodeArgs(args []string) string {
+	var b strings.Builder
+	for _, arg := range args {
+		b.WriteString(arg)
+		b.WriteString(argTerminator)
+	}
+	return b.String()
+}
+
+// encodeLegacyArgs encodes args newline-separated, for binaries built against earlier versions of bincover, which
+// don't decode argTerminator. Args with newlines or surrounding spaces, and empty args, don't survive decoding.
+func encodeLegacyArgs(args []string) string {
+	return strings.Join(args, "\n")
+}
+
+// decodeArgs decodes the contents of an args file written by encodeArgs. Contents that don't end with argTerminator
+// are newline-separated args, as written by earlier versions of CoverageCollector: each line is trimmed,
+// and empty lines are skipped.
+func decodeArgs(s string) []string {
+	if strings.HasSuffix(s, argTerminator) {
+		return strings.Split(strings.TrimSuffix(s, argTerminator), argTerminator)
+	}
+	var args []string
+	for _, arg := range strings.Split(s, "\n") {
 		arg = strings.TrimSpace(arg)
 		if len(arg) > 0 {
-			parsedCustomArgs = append(parsedCustomArgs, arg)
+			args = append(args, arg)
 		}
 	}
-	return parsedCustomArgs, nil
+	return args
 }
 
 type testMetadata struct {
 	CoverMode string `json:"cover_mode"`
 	ExitCode  int    `json:"exit_code"`
+	// Running is set in the metadata RunTest writes before calling f. If it is still set after the binary exits,
+	// f called os.Exit and the binary's exit code is f's exit code.
+	Running bool `json:"running,omitempty"`
+}
+
+func writeMetadata(metadata *testMetadata) error {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*metadataFilename, b, 0600)
 }
 
 func printMetadata(metadata *testMetadata) {
@@ -51,13 +91,20 @@ func printMetadata(metadata *testMetadata) {
 	fmt.Println(endOfMetadataMarker)
 }
 
-// RunTest runs function f (usually main), with arguments specified by the flag "args-file", a file of newline-separated args.
-// When f runs to completion (success or failure), RunTest prints (newline-separated):
+// RunTest runs function f (usually main), with arguments specified by the flag "args-file", a file of args each
+// followed by a NUL byte, so that f gets exactly the args passed to RunBinary.
+// When f runs to completion (success or failure), RunTest writes a testMetadata struct to the file specified by the flag
+// "metadata-file", and discards anything printed to stdout afterwards (such as the test framework's own output),
+// so that f's output is left untouched. If "metadata-file" is not set, RunTest instead prints (newline-separated):
 // 1. f's output,
 // 2. startOfMetadataMarker
 // 3. a testMetadata struct
 // 4. endOfMetadataMarker
 //
+// If f calls os.Exit instead of setting ExitCode, the metadata file tells the CoverageCollector running the binary
+// that f never returned, and the collector reports the binary's exit code instead. The coverage of such runs is
+// collected from the GOCOVERDIR the collector sets, which the Go runtime (1.20 and above) writes to on os.Exit.
+//
 // Otherwise, if an unexpected error is encountered during execution, RunTest panics.
 func RunTest(f func()) {
 	if !flag.Parsed() {
@@ -65,7 +112,7 @@ func RunTest(f func()) {
 	}
 	var parsedArgs []string
 	for _, arg := range os.Args {
-		if !strings.HasPrefix(arg, "-test.") && !strings.HasPrefix(arg, "-args-file") {
+		if !strings.HasPrefix(arg, "-test.") && !strings.HasPrefix(arg, "-args-file") && !strings.HasPrefix(arg, "-metadata-file") {
 			parsedArgs = append(parsedArgs, arg)
 		}
 	}
@@ -77,6 +124,15 @@ func RunTest(f func()) {
 		parsedArgs = append(parsedArgs, customArgs...)
 	}
 	os.Args = parsedArgs
+	if len(*metadataFilename) > 0 {
+		running := &testMetadata{
+			CoverMode: testing.CoverMode(),
+			Running:   true,
+		}
+		if err := writeMetadata(running); err != nil {
+			panic(err)
+		}
+	}
 	// Catch panicking binaries.
 	defer func() {
 		if r := recover(); r != nil {
@@ -87,7 +143,24 @@ func RunTest(f func()) {
 			CoverMode: testing.CoverMode(),
 			ExitCode:  ExitCode,
 		}
-		printMetadata(metadata)
+		if len(*metadataFilename) == 0 {
+			printMetadata(metadata)
+			return
+		}
+		if err := writeMetadata(metadata); err != nil {
+			panic(err)
+		}
+		discardStdout()
 	}()
 	f()
 }
+
+// discardStdout redirects os.Stdout to the null device, so that output printed after f returns,
+// which a real run of the binary would never print, does not end up in f's output.
+func discardStdout() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		panic(err)
+	}
+	os.Stdout = devNull
+}
@@ -22,6 +22,8 @@ func TestRunTest(t *testing.T) {
 		wantArgs          []string
 		wantPanic         bool
 		wantOutputPattern string
+		metadataFile      bool
+		wantMetadata      string
 	}{
 		{
 			name: "succeed running test",
@@ -36,6 +38,19 @@ func TestRunTest(t *testing.T) {
 			wantOutput: "The worst thing about prison was the Dementors\n" +
 				startOfMetadataMarker + "\n{\"cover_mode\":\"" + testing.CoverMode() + "\",\"exit_code\":0}\n" + endOfMetadataMarker + "\n",
 		},
+		{
+			name: "succeed running test with metadata file",
+			args: args{f: func() {
+				fmt.Println("Happy Hanukkah, Jesus")
+			}},
+			argsFile: func() *os.File {
+				return tempFile(t)
+			}(),
+			metadataFile: true,
+			wantArgs:     []string{},
+			wantOutput:   "Happy Hanukkah, Jesus\n",
+			wantMetadata: "{\"cover_mode\":\"" + testing.CoverMode() + "\",\"exit_code\":0}",
+		},
 		{
 			name: "fail running test when error parsing args file",
 			args: args{f: func() {
@@ -71,6 +86,17 @@ func TestRunTest(t *testing.T) {
 				argsFilename = &empty
 			}
 			defer resetArgsFileName()
+			var metadataFile *os.File
+			if tt.metadataFile {
+				metadataFile = tempFile(t)
+				defer os.Remove(metadataFile.Name())
+				m := metadataFile.Name()
+				metadataFilename = &m
+				defer func() {
+					var empty string
+					metadataFilename = &empty
+				}()
+			}
 			oldStdout := os.Stdout
 			defer func() { os.Stdout = oldStdout }()
 			tempStdout := tempFile(t)
@@ -81,6 +107,12 @@ func TestRunTest(t *testing.T) {
 			} else {
 				RunTest(tt.args.f)
 			}
+			if metadataFile != nil {
+				fmt.Println("Printed after RunTest returned")
+				buf, err := io.ReadAll(metadataFile)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantMetadata, string(buf))
+			}
 			_, err := tempStdout.Seek(0, 0)
 			require.NoError(t, err)
 			buf, err := io.ReadAll(tempStdout)
@@ -111,6 +143,27 @@ func Test_parseCustomArgs(t *testing.T) {
 			want:    []string{"first", "second", "third"},
 			wantErr: false,
 		},
+		{
+			name: "succeed parsing NUL-terminated args unchanged",
+			argsFile: func() *os.File {
+				return tempFileWithContent(t, encodeArgs([]string{"multi\nline", " padded ", "", "--name", ""}))
+			}(),
+			want: []string{"multi\nline", " padded ", "", "--name", ""},
+		},
+		{
+			name: "succeed parsing a single empty arg",
+			argsFile: func() *os.File {
+				return tempFileWithContent(t, encodeArgs([]string{""}))
+			}(),
+			want: []string{""},
+		},
+		{
+			name: "succeed parsing no args",
+			argsFile: func() *os.File {
+				return tempFileWithContent(t, encodeArgs(nil))
+			}(),
+			want: nil,
+		},
 		{
 			name: "fail parsing args when error reading from args file",
 			argsFile: func() *os.File {
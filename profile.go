@@ -0,0 +1,324 @@
+package bincover
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const modeHeaderPrefix = "mode: "
+
+// blockPosition identifies a basic block within a source file, as recorded in a coverage profile.
+type blockPosition struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+}
+
+type profileBlock struct {
+	blockPosition
+	NumStmt int
+	Count   int
+}
+
+// profile is a parsed coverage profile. Blocks are keyed by file and then by position, so that
+// merging repeated runs of the same binary combines counts instead of repeating blocks.
+type profile struct {
+	mode  string
+	files map[string]map[blockPosition]*profileBlock
+}
+
+func newProfile(mode string) *profile {
+	return &profile{
+		mode:  mode,
+		files: make(map[string]map[blockPosition]*profileBlock),
+	}
+}
+
+// parseProfile parses a coverage profile in the text format written by -test.coverprofile:
+// a "mode: <mode>" header followed by lines of the form "file:startLine.startCol,endLine.endCol numStmt count".
+func parseProfile(r io.Reader) (*profile, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var p *profile
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if p == nil {
+			if !strings.HasPrefix(line, modeHeaderPrefix) {
+				break
+			}
+			p = newProfile(strings.TrimPrefix(line, modeHeaderPrefix))
+			continue
+		}
+		file, block, err := parseProfileLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing coverage profile line %d", lineNum)
+		}
+		if err := p.addBlock(file, block); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading coverage profile")
+	}
+	if p == nil {
+		return nil, errors.New("error parsing coverage profile: missing coverage mode from coverage profile. Maybe the file got corrupted while writing?")
+	}
+	return p, nil
+}
+
+// Merge merges the coverage profiles at filenames, such as the profiles written by "go test -coverprofile" and
+// by a CoverageCollector, into a single profile written to output. Blocks that appear in several profiles are
+// merged like in TearDown. Profiles with different coverage modes are converted as described in mergedMode:
+// "count" and "atomic" profiles merge into a "count" profile, and merging with a "set" profile gives a "set" profile.
+func Merge(output string, filenames ...string) error {
+	if len(filenames) == 0 {
+		return errors.New("no coverage profiles to merge")
+	}
+	var merged *profile
+	for _, filename := range filenames {
+		p, err := readProfileFile(filename)
+		if err != nil {
+			return err
+		}
+		if merged == nil {
+			merged = p
+		} else if err := merged.merge(p); err != nil {
+			return errors.Wrapf(err, "error merging coverage profile \"%s\"", filename)
+		}
+	}
+	return writeProfileFile(output, merged)
+}
+
+// writeProfileFile writes p to filename.
+func writeProfileFile(filename string, p *profile) error {
+	var buf bytes.Buffer
+	if err := p.write(&buf); err != nil {
+		return errors.Wrap(err, "error merging coverage profiles")
+	}
+	return errors.Wrap(os.WriteFile(filename, buf.Bytes(), 0600), "error writing merged coverage profile")
+}
+
+// readProfileFile parses the coverage profile at filename.
+func readProfileFile(filename string) (*profile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening coverage profile")
+	}
+	defer file.Close()
+	p, err := parseProfile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading coverage profile \"%s\"", filename)
+	}
+	return p, nil
+}
+
+func parseProfileLine(line string) (file string, block profileBlock, err error) {
+	colon := strings.LastIndex(line, ":")
+	if colon == -1 {
+		return "", block, errors.Errorf("malformed block %q", line)
+	}
+	file = line[:colon]
+	fields := strings.Fields(line[colon+1:])
+	if len(fields) != 3 {
+		return "", block, errors.Errorf("malformed block %q", line)
+	}
+	var nums [6]int
+	rangeParts := strings.FieldsFunc(fields[0], func(r rune) bool { return r == '.' || r == ',' })
+	if len(rangeParts) != 4 {
+		return "", block, errors.Errorf("malformed block range %q", fields[0])
+	}
+	for i, s := range append(rangeParts, fields[1], fields[2]) {
+		nums[i], err = strconv.Atoi(s)
+		if err != nil {
+			return "", block, errors.Errorf("malformed block %q", line)
+		}
+	}
+	block = profileBlock{
+		blockPosition: blockPosition{StartLine: nums[0], StartCol: nums[1], EndLine: nums[2], EndCol: nums[3]},
+		NumStmt:       nums[4],
+		Count:         nums[5],
+	}
+	return file, block, nil
+}
+
+// addBlock adds block to the profile, combining its count with an existing block at the same position.
+func (p *profile) addBlock(file string, block profileBlock) error {
+	blocks, ok := p.files[file]
+	if !ok {
+		blocks = make(map[blockPosition]*profileBlock)
+		p.files[file] = blocks
+	}
+	existing, ok := blocks[block.blockPosition]
+	if !ok {
+		b := block
+		blocks[block.blockPosition] = &b
+		return nil
+	}
+	if existing.NumStmt != block.NumStmt {
+		return errors.Errorf("inconsistent number of statements for block %s:%s (%d vs %d)", file, block.blockPosition, existing.NumStmt, block.NumStmt)
+	}
+	existing.Count = combineCounts(p.mode, existing.Count, block.Count)
+	return nil
+}
+
+func combineCounts(mode string, a int, b int) int {
+	if mode == set {
+		if a > 0 || b > 0 {
+			return 1
+		}
+		return 0
+	}
+	return a + b
+}
+
+// merge adds every block of other into p. If the profiles have different coverage modes, p is first converted
+// to the mode returned by mergedMode.
+func (p *profile) merge(other *profile) error {
+	mode, err := mergedMode(p.mode, other.mode)
+	if err != nil {
+		return err
+	}
+	if err := p.convert(mode); err != nil {
+		return err
+	}
+	for file, blocks := range other.files {
+		for _, block := range blocks {
+			b := *block
+			b.Count = convertCount(mode, b.Count)
+			if err := p.addBlock(file, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergedMode returns the coverage mode of a profile merging profiles with modes a and b.
+// "count" and "atomic" profiles both record how often each block ran, so they merge into "count".
+// Counts can be turned into "set" data but not the other way around, so merging with a "set" profile gives "set".
+func mergedMode(a string, b string) (string, error) {
+	switch {
+	case a == b:
+		return a, nil
+	case !isCoverMode(a) || !isCoverMode(b):
+		return "", errorOf(ErrCoverModeMismatch, "cannot merge profiles with different coverage modes \"%s\" and \"%s\"", a, b)
+	case a == set || b == set:
+		return set, nil
+	default:
+		return count, nil
+	}
+}
+
+func isCoverMode(mode string) bool {
+	return mode == set || mode == count || mode == atomic
+}
+
+// convert changes the coverage mode of p to mode. Only conversions that don't make up data are allowed:
+// between "count" and "atomic", and from either of them to "set".
+func (p *profile) convert(mode string) error {
+	if p.mode == mode {
+		return nil
+	}
+	if !canConvertMode(p.mode, mode) {
+		return errorOf(ErrCoverModeMismatch, "cannot convert coverage profile from mode \"%s\" to \"%s\"", p.mode, mode)
+	}
+	for _, blocks := range p.files {
+		for _, block := range blocks {
+			block.Count = convertCount(mode, block.Count)
+		}
+	}
+	p.mode = mode
+	return nil
+}
+
+// canConvertMode reports whether coverage in mode from can be converted to mode to.
+func canConvertMode(from string, to string) bool {
+	return from == to || (isCoverMode(from) && isCoverMode(to) && from != set)
+}
+
+// convertCount converts the count of a block in a "count" or "atomic" profile to mode.
+func convertCount(mode string, n int) int {
+	if mode == set && n > 0 {
+		return 1
+	}
+	return n
+}
+
+// sortedFiles returns the names of the files in the profile in lexical order.
+func (p *profile) sortedFiles() []string {
+	files := make([]string, 0, len(p.files))
+	for file := range p.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// sortedBlocks returns the blocks of file ordered by position.
+// It returns an error if any two blocks overlap, which happens when profiles from different builds
+// of the same source file are merged.
+func (p *profile) sortedBlocks(file string) ([]*profileBlock, error) {
+	blocks := make([]*profileBlock, 0, len(p.files[file]))
+	for _, block := range p.files[file] {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].blockPosition.before(blocks[j].blockPosition)
+	})
+	for i := 1; i < len(blocks); i++ {
+		prev, cur := blocks[i-1], blocks[i]
+		if positionLess(cur.StartLine, cur.StartCol, prev.EndLine, prev.EndCol) {
+			return nil, errors.Errorf("overlapping blocks %s:%s and %s:%s", file, prev.blockPosition, file, cur.blockPosition)
+		}
+	}
+	return blocks, nil
+}
+
+// write writes the profile in the text format understood by "go tool cover".
+func (p *profile) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "%s%s\n", modeHeaderPrefix, p.mode); err != nil {
+		return err
+	}
+	for _, file := range p.sortedFiles() {
+		blocks, err := p.sortedBlocks(file)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if _, err := fmt.Fprintf(bw, "%s:%s %d %d\n", file, block.blockPosition, block.NumStmt, block.Count); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func (b blockPosition) String() string {
+	return fmt.Sprintf("%d.%d,%d.%d", b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+}
+
+func (b blockPosition) before(other blockPosition) bool {
+	if b.StartLine != other.StartLine || b.StartCol != other.StartCol {
+		return positionLess(b.StartLine, b.StartCol, other.StartLine, other.StartCol)
+	}
+	return positionLess(b.EndLine, b.EndCol, other.EndLine, other.EndCol)
+}
+
+func positionLess(line1 int, col1 int, line2 int, col2 int) bool {
+	return line1 < line2 || (line1 == line2 && col1 < col2)
+}
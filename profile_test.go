@@ -0,0 +1,148 @@
+package bincover
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantOutput string
+		wantErr    bool
+		errMessage string
+	}{
+		{
+			name:       "succeed parsing and sorting profile",
+			content:    "mode: count\nb.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\na.go:1.1,2.2 1 2\n",
+			wantOutput: "mode: count\na.go:1.1,2.2 1 2\na.go:3.1,4.2 2 0\nb.go:1.1,2.2 1 1\n",
+		},
+		{
+			name:       "succeed merging duplicate blocks within a profile",
+			content:    "mode: atomic\na.go:1.1,2.2 1 2\na.go:1.1,2.2 1 3\n",
+			wantOutput: "mode: atomic\na.go:1.1,2.2 1 5\n",
+		},
+		{
+			name:       "succeed parsing file names containing colons",
+			content:    "mode: set\nC:/a.go:1.1,2.2 1 1\n",
+			wantOutput: "mode: set\nC:/a.go:1.1,2.2 1 1\n",
+		},
+		{
+			name:       "fail parsing profile without mode",
+			content:    "a.go:1.1,2.2 1 1\n",
+			wantErr:    true,
+			errMessage: "error parsing coverage profile: missing coverage mode from coverage profile. Maybe the file got corrupted while writing?",
+		},
+		{
+			name:       "fail parsing malformed block",
+			content:    "mode: set\na.go:1.1,2.2 1\n",
+			wantErr:    true,
+			errMessage: "error parsing coverage profile line 2: malformed block \"a.go:1.1,2.2 1\"",
+		},
+		{
+			name:       "fail parsing block with inconsistent number of statements",
+			content:    "mode: set\na.go:1.1,2.2 1 1\na.go:1.1,2.2 2 1\n",
+			wantErr:    true,
+			errMessage: "inconsistent number of statements for block a.go:1.1,2.2 (1 vs 2)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parseProfile(strings.NewReader(tt.content))
+			if tt.wantErr {
+				require.EqualError(t, err, tt.errMessage)
+				return
+			}
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, p.write(&buf))
+			require.Equal(t, tt.wantOutput, buf.String())
+		})
+	}
+}
+
+func Test_profile_merge(t *testing.T) {
+	tests := []struct {
+		name       string
+		profiles   []string
+		wantOutput string
+		wantErr    bool
+		errMessage string
+	}{
+		{
+			name:       "succeed merging set profiles",
+			profiles:   []string{"mode: set\na.go:1.1,2.2 1 0\n", "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 0\n"},
+			wantOutput: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 0\n",
+		},
+		{
+			name:       "succeed merging atomic profiles",
+			profiles:   []string{"mode: atomic\na.go:1.1,2.2 1 4\n", "mode: atomic\na.go:1.1,2.2 1 6\n"},
+			wantOutput: "mode: atomic\na.go:1.1,2.2 1 10\n",
+		},
+		{
+			name:       "succeed merging set profile with count profile",
+			profiles:   []string{"mode: set\na.go:1.1,2.2 1 0\n", "mode: count\na.go:1.1,2.2 1 3\nb.go:1.1,2.2 1 2\n"},
+			wantOutput: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 1\n",
+		},
+		{
+			name:       "succeed merging atomic profile with set profile",
+			profiles:   []string{"mode: atomic\na.go:1.1,2.2 1 4\nb.go:1.1,2.2 1 0\n", "mode: set\na.go:1.1,2.2 1 0\n"},
+			wantOutput: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 0\n",
+		},
+		{
+			name:       "succeed merging count profile with atomic profile",
+			profiles:   []string{"mode: count\na.go:1.1,2.2 1 4\n", "mode: atomic\na.go:1.1,2.2 1 6\n"},
+			wantOutput: "mode: count\na.go:1.1,2.2 1 10\n",
+		},
+		{
+			name:       "fail merging profiles with unknown mode",
+			profiles:   []string{"mode: set\na.go:1.1,2.2 1 0\n", "mode: bogus\na.go:1.1,2.2 1 1\n"},
+			wantErr:    true,
+			errMessage: "cannot merge profiles with different coverage modes \"set\" and \"bogus\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := parseProfile(strings.NewReader(tt.profiles[0]))
+			require.NoError(t, err)
+			for _, content := range tt.profiles[1:] {
+				p, err := parseProfile(strings.NewReader(content))
+				require.NoError(t, err)
+				err = merged.merge(p)
+				if tt.wantErr {
+					require.EqualError(t, err, tt.errMessage)
+					return
+				}
+				require.NoError(t, err)
+			}
+			var buf bytes.Buffer
+			require.NoError(t, merged.write(&buf))
+			require.Equal(t, tt.wantOutput, buf.String())
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	unitFilename := filepath.Join(dir, "unit.out")
+	integFilename := filepath.Join(dir, "integ.out")
+	output := filepath.Join(dir, "merged.out")
+	require.NoError(t, os.WriteFile(unitFilename, []byte("mode: atomic\na.go:1.1,2.2 1 2\nb.go:1.1,2.2 1 0\n"), 0600))
+	require.NoError(t, os.WriteFile(integFilename, []byte("mode: count\nb.go:1.1,2.2 1 1\na.go:1.1,2.2 1 3\n"), 0600))
+	require.NoError(t, Merge(output, unitFilename, integFilename))
+	buf, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "mode: count\na.go:1.1,2.2 1 5\nb.go:1.1,2.2 1 1\n", string(buf))
+
+	require.EqualError(t, Merge(output), "no coverage profiles to merge")
+	missing := filepath.Join(dir, "missing.out")
+	err = Merge(output, unitFilename, missing)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error opening coverage profile")
+}
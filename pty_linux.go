@@ -0,0 +1,70 @@
+package bincover
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPtyRows = 24
+	defaultPtyCols = 80
+)
+
+// openPty opens a new pseudo-terminal, returning its controlling (master) side and the terminal (slave) side.
+func openPty() (ptm *os.File, pts *os.File, err error) {
+	ptm, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error opening pty")
+	}
+	defer func() {
+		if err != nil {
+			_ = ptm.Close()
+		}
+	}()
+	var unlock int32
+	if err := ptyIoctl(ptm, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		return nil, nil, errors.Wrap(err, "error unlocking pty")
+	}
+	var ptyNumber uint32
+	if err := ptyIoctl(ptm, syscall.TIOCGPTN, unsafe.Pointer(&ptyNumber)); err != nil {
+		return nil, nil, errors.Wrap(err, "error getting pty number")
+	}
+	// Some prompt libraries refuse to draw on a terminal without a size.
+	winSize := struct{ rows, cols, x, y uint16 }{rows: defaultPtyRows, cols: defaultPtyCols}
+	if err := ptyIoctl(ptm, syscall.TIOCSWINSZ, unsafe.Pointer(&winSize)); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting pty window size")
+	}
+	pts, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", ptyNumber), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error opening pty terminal")
+	}
+	return ptm, pts, nil
+}
+
+// ptyIoctl runs an ioctl on file without switching it to blocking mode, as file.Fd() would.
+func ptyIoctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptySysProcAttr makes the pty the controlling terminal of a new session, like a login shell would.
+func ptySysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
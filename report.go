@@ -0,0 +1,275 @@
+package bincover
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const hitLevels = 5
+
+// coverageStats counts the statements of a file, package or profile, and how many of them were executed.
+type coverageStats struct {
+	Covered int
+	Total   int
+}
+
+func (s *coverageStats) add(block *profileBlock) {
+	s.Total += block.NumStmt
+	if block.Count > 0 {
+		s.Covered += block.NumStmt
+	}
+}
+
+func (s *coverageStats) addStats(other coverageStats) {
+	s.Covered += other.Covered
+	s.Total += other.Total
+}
+
+// Percent returns the percentage of covered statements, or 0 if there are none.
+func (s coverageStats) Percent() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return 100 * float64(s.Covered) / float64(s.Total)
+}
+
+// lineCoverage is the coverage of a single source line, combined from every block spanning it.
+type lineCoverage struct {
+	// Count is the highest count of the blocks spanning the line.
+	Count int
+	// Blocks is the number of blocks spanning the line, and BlocksHit the number of them that were executed.
+	Blocks    int
+	BlocksHit int
+}
+
+// lineCoverages returns the coverage of every line spanned by blocks, keyed by line number.
+func lineCoverages(blocks []*profileBlock) map[int]*lineCoverage {
+	lines := make(map[int]*lineCoverage)
+	for _, block := range blocks {
+		if block.NumStmt == 0 {
+			continue
+		}
+		for line := block.StartLine; line <= block.EndLine; line++ {
+			lc, ok := lines[line]
+			if !ok {
+				lc = &lineCoverage{}
+				lines[line] = lc
+			}
+			if block.Count > lc.Count {
+				lc.Count = block.Count
+			}
+			lc.Blocks++
+			if block.Count > 0 {
+				lc.BlocksHit++
+			}
+		}
+	}
+	return lines
+}
+
+// WriteHTMLReport writes a self-contained HTML report of the merged coverage profile to filename.
+// It must be called after TearDown. See HTMLReport.
+func (c *CoverageCollector) WriteHTMLReport(filename string) error {
+	var buf bytes.Buffer
+	if err := HTMLReport(c.MergedCoverageFilename, &buf); err != nil {
+		return err
+	}
+	return errors.Wrap(os.WriteFile(filename, buf.Bytes(), 0644), "error writing HTML report")
+}
+
+// HTMLReport writes an HTML report of the coverage profile at profileFilename to w.
+// The report lists the coverage of every package and file, and shows the source of every file with each line
+// highlighted by how often it ran. It has no external assets, so it can be viewed offline or kept as a CI artifact.
+// Source files are looked up through the go.mod of the current directory's module, or "go list" for packages
+// outside of it; files that can't be found are reported without their source.
+func HTMLReport(profileFilename string, w io.Writer) error {
+	p, err := readProfileFile(profileFilename)
+	if err != nil {
+		return err
+	}
+	resolver, err := newSourceResolver(".")
+	if err != nil {
+		return err
+	}
+	report, err := newHTMLReport(p, resolver)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(htmlReportTemplate.Execute(w, report), "error writing HTML report")
+}
+
+type htmlReport struct {
+	Mode     string
+	Total    coverageStats
+	Packages []*htmlPackage
+}
+
+type htmlPackage struct {
+	Name  string
+	Stats coverageStats
+	Files []*htmlFile
+}
+
+type htmlFile struct {
+	ID    string
+	Name  string
+	Stats coverageStats
+	// SourceErr explains why Lines is empty, if the source could not be read.
+	SourceErr string
+	Lines     []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	// Class is empty for lines without statements.
+	Class string
+	Hits  string
+}
+
+func newHTMLReport(p *profile, resolver *sourceResolver) (*htmlReport, error) {
+	files := p.sortedFiles()
+	resolver.load(files)
+	maxCount := 0
+	for _, blocks := range p.files {
+		for _, block := range blocks {
+			if block.Count > maxCount {
+				maxCount = block.Count
+			}
+		}
+	}
+	report := &htmlReport{Mode: p.mode}
+	packages := make(map[string]*htmlPackage)
+	for i, name := range files {
+		blocks, err := p.sortedBlocks(name)
+		if err != nil {
+			return nil, err
+		}
+		file := &htmlFile{ID: fmt.Sprintf("file%d", i), Name: name}
+		for _, block := range blocks {
+			file.Stats.add(block)
+		}
+		file.Lines, file.SourceErr = htmlLines(resolver, name, blocks, p.mode, maxCount)
+		pkgName := path.Dir(name)
+		pkg, ok := packages[pkgName]
+		if !ok {
+			pkg = &htmlPackage{Name: pkgName}
+			packages[pkgName] = pkg
+			report.Packages = append(report.Packages, pkg)
+		}
+		pkg.Files = append(pkg.Files, file)
+		pkg.Stats.addStats(file.Stats)
+		report.Total.addStats(file.Stats)
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		return report.Packages[i].Name < report.Packages[j].Name
+	})
+	return report, nil
+}
+
+// htmlLines returns the lines of the source of file, highlighted by the coverage of blocks,
+// or the reason the source can't be shown.
+func htmlLines(resolver *sourceResolver, file string, blocks []*profileBlock, mode string, maxCount int) ([]htmlLine, string) {
+	filename, ok := resolver.resolve(file)
+	if !ok {
+		return nil, "source file not found"
+	}
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err.Error()
+	}
+	coverages := lineCoverages(blocks)
+	var lines []htmlLine
+	for i, text := range strings.Split(strings.TrimSuffix(string(src), "\n"), "\n") {
+		line := htmlLine{Number: i + 1, Text: strings.TrimSuffix(text, "\r")}
+		if lc, ok := coverages[line.Number]; ok {
+			line.Class, line.Hits = lineClass(lc, mode, maxCount), fmt.Sprintf("%d", lc.Count)
+		}
+		lines = append(lines, line)
+	}
+	return lines, ""
+}
+
+// lineClass returns the CSS class highlighting a line with coverage lc. Executed lines get one of hitLevels shades,
+// on a logarithmic scale of their count relative to maxCount, unless mode is "set", which has no counts.
+func lineClass(lc *lineCoverage, mode string, maxCount int) string {
+	switch {
+	case lc.Count == 0:
+		return "miss"
+	case lc.BlocksHit < lc.Blocks:
+		return "partial"
+	case mode == set || maxCount <= 1:
+		return fmt.Sprintf("hit%d", hitLevels)
+	}
+	level := 1 + int(math.Log(float64(lc.Count))/math.Log(float64(maxCount))*(hitLevels-1))
+	return fmt.Sprintf("hit%d", level)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(s coverageStats) string {
+		return fmt.Sprintf("%.1f%%", s.Percent())
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Coverage report</title>
+<style>
+body { font-family: sans-serif; margin: 0; color: #222; }
+header { background: #333; color: #eee; padding: 12px 20px; }
+header h1 { font-size: 18px; margin: 0; }
+main { padding: 12px 20px; }
+table.summary { border-collapse: collapse; }
+table.summary td { padding: 2px 12px 2px 0; }
+td.pct { text-align: right; font-variant-numeric: tabular-nums; }
+td.stmts { color: #777; text-align: right; }
+.bar { width: 120px; height: 8px; background: #e6b0b0; }
+.bar span { display: block; height: 100%; background: #5a5; }
+details { margin: 4px 0; }
+summary { cursor: pointer; }
+details table { margin-left: 20px; }
+.file { display: none; }
+.file:target { display: block; }
+.file h2 { font-size: 16px; }
+table.source { border-collapse: collapse; font-family: monospace; font-size: 13px; }
+table.source td { padding: 0 8px; white-space: pre; }
+td.num, td.hits { color: #999; text-align: right; user-select: none; }
+.miss { background: #f3c4c4; }
+.partial { background: #f5e3a8; }
+.hit1 { background: #e3f4e3; }
+.hit2 { background: #cdebcd; }
+.hit3 { background: #b4e0b4; }
+.hit4 { background: #9bd59b; }
+.hit5 { background: #80c980; }
+</style>
+</head>
+<body>
+<header><h1>Coverage report: {{percent .Total}} of {{.Total.Total}} statements (mode: {{.Mode}})</h1></header>
+<main>
+{{range .Packages}}<details>
+<summary>{{.Name}} &mdash; {{percent .Stats}} ({{.Stats.Covered}}/{{.Stats.Total}})</summary>
+<table class="summary">
+{{range .Files}}<tr><td><a href="#{{.ID}}">{{.Name}}</a></td><td class="pct">{{percent .Stats}}</td><td><div class="bar"><span style="width: {{printf "%.0f" .Stats.Percent}}%"></span></div></td><td class="stmts">{{.Stats.Covered}}/{{.Stats.Total}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}
+{{range .Packages}}{{range .Files}}<section class="file" id="{{.ID}}">
+<h2>{{.Name}} &mdash; {{percent .Stats}}</h2>
+{{if .SourceErr}}<p>{{.SourceErr}}</p>{{else}}<table class="source">
+{{range .Lines}}<tr{{if .Class}} class="{{.Class}}"{{end}}><td class="num">{{.Number}}</td><td class="hits">{{.Hits}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>{{end}}
+</section>
+{{end}}{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,59 @@
+package bincover
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLReport(t *testing.T) {
+	profile := `mode: count
+github.com/confluentinc/bincover/test_bins/set_covermode.go:9.13,11.22 2 4
+github.com/confluentinc/bincover/test_bins/set_covermode.go:11.22,12.2 1 0
+example.com/missing/pkg/file.go:1.1,2.2 3 0
+`
+	profileFilename := filepath.Join(t.TempDir(), "coverage.out")
+	require.NoError(t, os.WriteFile(profileFilename, []byte(profile), 0600))
+	var buf bytes.Buffer
+	require.NoError(t, HTMLReport(profileFilename, &buf))
+	report := buf.String()
+	for _, want := range []string{
+		"Coverage report: 33.3% of 6 statements (mode: count)",
+		"<summary>github.com/confluentinc/bincover/test_bins &mdash; 66.7% (2/3)</summary>",
+		"<summary>example.com/missing/pkg &mdash; 0.0% (0/3)</summary>",
+		`<tr class="hit5"><td class="num">10</td><td class="hits">4</td><td>	fmt.Println(&#34;Hello world&#34;)</td></tr>`,
+		`<tr class="partial"><td class="num">11</td><td class="hits">4</td>`,
+		`<tr class="miss"><td class="num">12</td><td class="hits">0</td><td>}</td></tr>`,
+		`<tr><td class="num">1</td><td class="hits"></td><td>package main</td></tr>`,
+		"<p>source file not found</p>",
+	} {
+		require.Contains(t, report, want)
+	}
+	require.NotContains(t, report, "<script")
+	require.NotContains(t, report, "http")
+}
+
+func Test_lineClass(t *testing.T) {
+	tests := []struct {
+		name     string
+		lc       lineCoverage
+		mode     string
+		maxCount int
+		want     string
+	}{
+		{name: "missed line", lc: lineCoverage{Count: 0, Blocks: 1}, mode: count, maxCount: 10, want: "miss"},
+		{name: "partially covered line", lc: lineCoverage{Count: 3, Blocks: 2, BlocksHit: 1}, mode: count, maxCount: 10, want: "partial"},
+		{name: "line hit once", lc: lineCoverage{Count: 1, Blocks: 1, BlocksHit: 1}, mode: count, maxCount: 100, want: "hit1"},
+		{name: "line hit often", lc: lineCoverage{Count: 10, Blocks: 1, BlocksHit: 1}, mode: count, maxCount: 100, want: "hit3"},
+		{name: "line hit most", lc: lineCoverage{Count: 100, Blocks: 1, BlocksHit: 1}, mode: atomic, maxCount: 100, want: "hit5"},
+		{name: "line covered in set mode", lc: lineCoverage{Count: 1, Blocks: 1, BlocksHit: 1}, mode: set, maxCount: 1, want: "hit5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, lineClass(&tt.lc, tt.mode, tt.maxCount))
+		})
+	}
+}
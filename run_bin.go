@@ -1,13 +1,16 @@
 package bincover
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -18,18 +21,68 @@ const (
 	atomic                       = "atomic"
 	defaultTmpArgsFilePrefix     = "integ_args"
 	defaultTmpCoverageFilePrefix = "temp_coverage"
+	defaultTmpMetadataFilePrefix = "bincover_metadata"
 )
 
 type CoverageCollector struct {
 	MergedCoverageFilename string
 	CollectCoverage        bool
-	tmpArgsFile            *os.File
-	coverMode              string
-	tmpCoverageFiles       []*os.File
-	setupFinished          bool
-	preCmdFuncs            []PreCmdFunc
-	postCmdFuncs           []PostCmdFunc
+	// UseGoCoverDir makes RunBinary run binaries built with "go build -cover" (Go 1.20 and above)
+	// instead of test binaries built with "go test -c". Each run writes its coverage data to its own GOCOVERDIR,
+	// and TearDown converts the collected data into a text profile at MergedCoverageFilename.
+	UseGoCoverDir bool
+	// TerminationGracePeriod is how long RunBinaryContext waits for a binary to exit after sending it SIGTERM
+	// before sending SIGKILL. Defaults to 5 seconds.
+	TerminationGracePeriod time.Duration
+	// MaxConcurrency limits how many binaries RunBinary runs at the same time. Zero means no limit.
+	// It must be set before Setup is called.
+	MaxConcurrency int
+	// thresholds holds the minimum coverage TearDown enforces.
+	thresholds coverageThresholds
+	// runConfig holds the defaults for every run, which options passed to RunBinary override for a single run.
+	runConfig
+	setupFinished bool
+	sem           chan struct{}
+	// mu guards the fields below, which are shared by concurrent runs.
+	mu               sync.Mutex
+	coverMode        string
+	tmpCoverageFiles []*os.File
+	tmpCoverDirs     []string
+	// runLabels holds the labels of the runs that wrote tmpCoverageFiles and tmpCoverDirs, keyed by path.
+	// Runs without a label are left out.
+	runLabels map[string]string
+	// attribution is the per-run coverage computed by the last TearDown.
+	attribution *CoverageAttribution
+	// profiles holds the profiles added with AddProfile.
+	profiles []*profile
+	// targetCoverMode is the mode set with TargetCoverMode.
+	targetCoverMode string
+	// includedProfiles are the files of the profiles added with IncludeProfiles, which TearDown reads.
+	includedProfiles []string
+	// metadataFileSupport records, by path, whether test binaries accept the -metadata-file flag.
+	// Binaries built against older versions of bincover don't, and print their metadata instead.
+	metadataFileSupport map[string]bool
 }
+
+// runConfig holds the settings of a single run that can be changed with a CoverageCollectorOption.
+type runConfig struct {
+	preCmdFuncs  []PreCmdFunc
+	postCmdFuncs []PostCmdFunc
+	// stdin opens the binary's standard input. Nil means the binary reads from the null device.
+	stdin             func() (io.ReadCloser, error)
+	sandbox           bool
+	sandboxFixtureDir string
+	envPolicy         EnvPolicy
+	dir               string
+	// label names the run in the coverage attribution.
+	label string
+	// expectSuccess makes Exec return an error if the binary exits unsuccessfully.
+	expectSuccess bool
+	// combineOutput captures stdout and stderr through a single pipe, which keeps the order they were written in,
+	// but leaves Stdout holding both streams and Stderr empty.
+	combineOutput bool
+}
+
 type CoverageCollectorOption func(collector *CoverageCollector)
 type PreCmdFunc func(cmd *exec.Cmd) error
 type PostCmdFunc func(cmd *exec.Cmd, output string, err error) error
@@ -38,194 +91,846 @@ type PostCmdFunc func(cmd *exec.Cmd, output string, err error) error
 // merged coverage filename. CollectCoverage can be set to true to collect coverage,
 // or set to false to skip coverage collection. This is provided in order to enable reuse of CoverageCollector
 // for tests where coverage measurement is not needed.
-func NewCoverageCollector(mergedCoverageFilename string, collectCoverage bool) *CoverageCollector {
-	return &CoverageCollector{
+// Options apply to every run of RunBinary.
+func NewCoverageCollector(mergedCoverageFilename string, collectCoverage bool, options ...CoverageCollectorOption) *CoverageCollector {
+	c := &CoverageCollector{
 		MergedCoverageFilename: mergedCoverageFilename,
 		CollectCoverage:        collectCoverage,
 	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
 func (c *CoverageCollector) Setup() error {
 	if c.MergedCoverageFilename == "" && c.CollectCoverage {
 		return errors.New("merged coverage profile filename cannot be empty when CollectCoverage is true")
 	}
-	var err error
-	c.tmpArgsFile, err = os.CreateTemp("", defaultTmpArgsFilePrefix)
-	if err != nil {
-		return errors.Wrap(err, "error creating temporary args file")
+	if c.targetCoverMode != "" && !isCoverMode(c.targetCoverMode) {
+		return errors.Errorf("unexpected target coverage mode \"%s\". Coverage mode must be set, count, or atomic", c.targetCoverMode)
+	}
+	if c.MaxConcurrency > 0 {
+		c.sem = make(chan struct{}, c.MaxConcurrency)
 	}
 	c.setupFinished = true
 	return nil
 }
 
 // TearDown merges the coverage profiles collecting from repeated runs of RunBinary.
+// Blocks that appear in several profiles are merged into a single block: their counts are OR'ed in "set" mode
+// and added in "count" and "atomic" mode.
 // It must be called at the teardown stage of the test suite, otherwise no merged coverage profile will be created.
+// If coverage is below a minimum set with MinCoverage, MinPackageCoverage or MinFileCoverage, TearDown returns
+// a *CoverageThresholdError after writing the merged profile. If CollectCoverage is set but no coverage was collected
+// at all, no profile is written, and the coverage checked against the minimums is 0%. Minimums are not checked
+// when CollectCoverage is not set.
 func (c *CoverageCollector) TearDown() error {
-	if len(c.tmpCoverageFiles) == 0 {
-		return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.tmpCoverageFiles) == 0 && len(c.tmpCoverDirs) == 0 && len(c.profiles) == 0 && len(c.includedProfiles) == 0 {
+		return c.checkEmptyCoverage()
 	}
 	defer c.removeTempFiles()
-	header := fmt.Sprintf("mode: %s", c.coverMode)
-	var parsedProfiles []string
+	for _, filename := range c.includedProfiles {
+		p, err := readProfileFile(filename)
+		if err != nil {
+			return err
+		}
+		c.profiles = append(c.profiles, p)
+	}
+	runProfiles, err := c.runProfiles()
+	if err != nil {
+		return err
+	}
+	profiles := append([]*profile(nil), c.profiles...)
+	for _, label := range sortedLabels(runProfiles) {
+		profiles = append(profiles, runProfiles[label])
+	}
+	if len(profiles) == 0 {
+		return c.checkEmptyCoverage()
+	}
+	// The run profiles are kept apart for the attribution, so merge them into a new profile.
+	merged := newProfile(profiles[0].mode)
+	for _, p := range profiles {
+		if err := merged.merge(p); err != nil {
+			return errors.Wrap(err, "error merging coverage profiles")
+		}
+	}
+	if c.targetCoverMode != "" {
+		if err := merged.convert(c.targetCoverMode); err != nil {
+			return errors.Wrap(err, "error merging coverage profiles")
+		}
+	}
+	if err := writeProfileFile(c.MergedCoverageFilename, merged); err != nil {
+		return err
+	}
+	if c.attribution, err = newCoverageAttribution(merged.mode, runProfiles); err != nil {
+		return err
+	}
+	return c.thresholds.check(merged)
+}
+
+// checkEmptyCoverage checks the minimums against a suite that collected no coverage, which is 0% covered
+// unless coverage collection is disabled.
+func (c *CoverageCollector) checkEmptyCoverage() error {
+	if !c.CollectCoverage {
+		return nil
+	}
+	return c.thresholds.check(newProfile(set))
+}
+
+// runProfiles parses the coverage written by every run, keyed by label. Runs sharing a label are merged.
+func (c *CoverageCollector) runProfiles() (map[string]*profile, error) {
+	profiles := make(map[string]*profile)
+	add := func(label string, p *profile) error {
+		existing, ok := profiles[label]
+		if !ok {
+			profiles[label] = p
+			return nil
+		}
+		return errors.Wrap(existing.merge(p), "error merging coverage profiles")
+	}
 	for _, file := range c.tmpCoverageFiles {
 		buf, err := io.ReadAll(file)
 		if err != nil {
-			return errors.Wrap(err, "error reading temp coverage profiles")
+			return nil, errors.Wrap(err, "error reading temp coverage profiles")
 		}
-		profile := string(buf)
-		loc := strings.Index(profile, header)
-		if loc == -1 {
-			errMessage := "error parsing coverage profile: missing coverage mode from coverage profile. Maybe the file got corrupted while writing?"
-			return errors.New(errMessage)
+		p, err := parseProfile(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		if err := add(c.runLabels[file.Name()], p); err != nil {
+			return nil, err
+		}
+	}
+	// Converting cover dirs is slow, so convert all the dirs of a label at once.
+	var labels []string
+	dirsByLabel := make(map[string][]string)
+	for _, dir := range c.tmpCoverDirs {
+		label := c.runLabels[dir]
+		if _, ok := dirsByLabel[label]; !ok {
+			labels = append(labels, label)
 		}
-		parsedProfile := strings.TrimSpace(profile[loc+len(header):])
-		parsedProfiles = append(parsedProfiles, parsedProfile)
+		dirsByLabel[label] = append(dirsByLabel[label], dir)
 	}
-	mergedProfile := fmt.Sprintf("%s\n%s", header, strings.Join(parsedProfiles, "\n"))
-	err := os.WriteFile(c.MergedCoverageFilename, []byte(mergedProfile), 0600)
+	for _, label := range labels {
+		p, err := profileFromCoverDirs(dirsByLabel[label])
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if err := add(label, p); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// AddProfile adds the coverage profile at filename, e.g. one written by a previous test suite, to the profiles
+// TearDown merges. The file itself is left untouched. Its coverage mode may differ from that of the runs:
+// see Merge for how modes are combined.
+func (c *CoverageCollector) AddProfile(filename string) error {
+	p, err := readProfileFile(filename)
 	if err != nil {
-		return errors.Wrap(err, "error writing merged coverage profile")
+		return err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.profiles = append(c.profiles, p)
 	return nil
 }
 
+// IncludeProfiles makes TearDown merge the coverage profiles at filenames, such as the unit.out written by
+// "go test -coverprofile=unit.out ./...", into the merged profile. Unlike AddProfile, the files are only read
+// by TearDown, so they may be written while the suite runs. It must be passed to NewCoverageCollector.
+func IncludeProfiles(filenames ...string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.includedProfiles = append(c.includedProfiles, filenames...)
+	}
+}
+
+// TargetCoverMode sets the coverage mode of the merged profile. By default, runs and profiles with different
+// modes are merged as described in Merge: "count" and "atomic" coverage merges into "count", and merging with
+// "set" coverage gives "set". With a target mode, coverage is converted to mode instead, and a run or profile that
+// can't be converted, such as a "set" run with a "count" target, makes RunBinary or TearDown return an error.
+// It must be passed to NewCoverageCollector.
+func TargetCoverMode(mode string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.targetCoverMode = mode
+	}
+}
+
+// PreExec calls preCmdFuncs with the command of the binary before it is started. Passed to NewCoverageCollector,
+// they are called for every run; passed to RunBinary, they replace those for that run only, and later runs are
+// left unchanged.
 func PreExec(preCmdFuncs ...PreCmdFunc) CoverageCollectorOption {
 	return func(c *CoverageCollector) {
 		c.preCmdFuncs = preCmdFuncs
 	}
 }
 
+// PostExec calls postCmdFuncs with the command of the binary and its output after it has exited successfully.
+// Like PreExec, they apply to every run when passed to NewCoverageCollector, and to a single run otherwise.
 func PostExec(postCmdFuncs ...PostCmdFunc) CoverageCollectorOption {
 	return func(c *CoverageCollector) {
 		c.postCmdFuncs = postCmdFuncs
 	}
 }
 
+// Stdin makes the binary read its standard input from r. r is written to the binary while its output is being
+// captured, so large inputs don't block the binary. Since r can only be read once, Stdin should be passed to a
+// single call of RunBinary rather than to NewCoverageCollector.
+// If r is an *os.File, such as os.Stdin, the binary reads from it directly, and it is left open.
+func Stdin(r io.Reader) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.stdin = func() (io.ReadCloser, error) {
+			if file, ok := r.(*os.File); ok {
+				return sharedFile{file}, nil
+			}
+			return io.NopCloser(r), nil
+		}
+	}
+}
+
+// sharedFile is a file passed to Stdin, which belongs to the caller and is not closed after the run.
+type sharedFile struct {
+	*os.File
+}
+
+func (sharedFile) Close() error {
+	return nil
+}
+
+// StdinString makes the binary read s from its standard input.
+func StdinString(s string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.stdin = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(s)), nil
+		}
+	}
+}
+
+// StdinFile makes the binary read its standard input from the file at name, which is opened anew for every run.
+func StdinFile(name string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.stdin = func() (io.ReadCloser, error) {
+			file, err := os.Open(name)
+			if err != nil {
+				return nil, errors.Wrap(err, "error opening stdin file")
+			}
+			return file, nil
+		}
+	}
+}
+
+// RunResult holds the output of a single run of an instrumented binary.
+type RunResult struct {
+	// Stdout and Stderr hold what the binary wrote to each stream.
+	Stdout string
+	Stderr string
+	// Combined holds the output of both streams, interleaved in the order it was written. Run and Exec read each
+	// stream through its own pipe, so the order between stdout and stderr is best-effort: writes made close together
+	// may be swapped. RunBinary and RunBinaryContext read both streams through a single pipe and keep the exact order.
+	Combined string
+	ExitCode int
+	// Signal is the signal that killed the binary, or nil if it exited by itself. ExitCode is -1 if it is set.
+	Signal os.Signal
+	// Duration is how long the binary ran.
+	Duration time.Duration
+	// CoverMode is the coverage mode reported by the binary. It is empty with UseGoCoverDir.
+	CoverMode string
+	// CoverProfile is the path of the coverage the run wrote: a text profile, or the run's GOCOVERDIR
+	// if the binary was built with "go build -cover" or called os.Exit. It is empty if no coverage was kept,
+	// and the file is removed by TearDown.
+	CoverProfile string
+	// Args are the arguments the binary was run with, not counting the flags added by the collector.
+	Args []string
+	// Env is the resolved environment the binary ran with, including the variables set by the collector.
+	Env []string
+	// SandboxFiles holds the contents of the files left in the run's sandbox directory, keyed by slash-separated
+	// path relative to the directory. It is nil unless the Sandbox option is used.
+	SandboxFiles map[string]string
+}
+
 // RunBinary runs the instrumented binary at binPath with env environment variables, executing only the test with mainTestName with the specified args.
+// If mainTestName is empty, DefaultMainTestName, the name of the test generated by Build, is run.
+// When UseGoCoverDir is set, binPath is run directly with args and mainTestName is ignored.
+// Options apply to this run only. RunBinary is safe for concurrent use.
 func (c *CoverageCollector) RunBinary(binPath string, mainTestName string, env []string, args []string, options ...CoverageCollectorOption) (output string, exitCode int, err error) {
+	return c.RunBinaryContext(context.Background(), binPath, mainTestName, env, args, options...)
+}
+
+// RunBinaryContext is like RunBinary, but stops the binary if ctx ends before the binary exits.
+// The binary is sent SIGTERM, followed by SIGKILL if it has not exited after TerminationGracePeriod,
+// and a *TimeoutError holding the output captured so far is returned.
+// Coverage written by the binary before it was stopped is kept.
+func (c *CoverageCollector) RunBinaryContext(ctx context.Context, binPath string, mainTestName string, env []string, args []string, options ...CoverageCollectorOption) (output string, exitCode int, err error) {
+	result, err := c.run(ctx, true, binPath, mainTestName, env, args, options...)
+	if err != nil {
+		if result != nil {
+			return "", result.ExitCode, err
+		}
+		return "", -1, err
+	}
+	return result.Combined, result.ExitCode, nil
+}
+
+// Run is like RunBinaryContext, but returns stdout and stderr separately as well as combined.
+// The bincover metadata is removed from whichever stream carries it.
+// If the binary exits unsuccessfully, Run returns the error together with a RunResult holding the raw output and exit code.
+// Since the streams are read separately, the order of Combined is best-effort; see RunResult.
+func (c *CoverageCollector) Run(ctx context.Context, binPath string, mainTestName string, env []string, args []string, options ...CoverageCollectorOption) (*RunResult, error) {
+	return c.run(ctx, false, binPath, mainTestName, env, args, options...)
+}
+
+// run runs the binary at binPath. If combineOutput is set, stdout and stderr are captured through a single pipe.
+func (c *CoverageCollector) run(ctx context.Context, combineOutput bool, binPath string, mainTestName string, env []string, args []string, options ...CoverageCollectorOption) (*RunResult, error) {
 	if !c.setupFinished {
-		panic("RunBinary called before Setup")
+		return nil, errors.WithStack(ErrNotSetUp)
 	}
-	err = c.writeArgs(args)
+	cfg, err := c.runConfigWith(options)
 	if err != nil {
-		return "", -1, err
+		return nil, err
 	}
-	for _, option := range options {
-		option(c)
+	cfg.combineOutput = combineOutput
+	if err := c.acquire(ctx); err != nil {
+		return nil, err
 	}
-	var binArgs string
-	var tempCovFile *os.File
-	if c.CollectCoverage {
-		tempCovFile, err = os.CreateTemp("", defaultTmpCoverageFilePrefix)
+	defer c.release()
+	if c.UseGoCoverDir {
+		return c.runCoverDirBinary(ctx, cfg, binPath, env, args)
+	}
+	stdin, closeStdin, err := cfg.openStdin()
+	if err != nil {
+		return nil, err
+	}
+	defer closeStdin()
+	supported, known := c.supportsMetadataFile(binPath)
+	// A binary that doesn't accept -metadata-file exits with a usage error before reading its input, but part of
+	// the input may already have been written to it. That part is kept to be written again when it is rerun.
+	var consumed bytes.Buffer
+	firstStdin, teed := stdin, false
+	if _, isFile := stdin.(*os.File); !known && stdin != nil && !isFile {
+		firstStdin, teed = io.TeeReader(stdin, &consumed), true
+	}
+	result, err := c.runTestBinary(ctx, cfg, binPath, mainTestName, env, args, firstStdin, supported)
+	if errors.Is(err, errMetadataFileUnsupported) {
+		if teed {
+			stdin = io.MultiReader(&consumed, stdin)
+		}
+		result, err = c.runTestBinary(ctx, cfg, binPath, mainTestName, env, args, stdin, false)
+	}
+	return result, err
+}
+
+// errMetadataFileUnsupported is returned by runTestBinary if the binary exited with a usage error because it
+// doesn't accept the -metadata-file flag.
+var errMetadataFileUnsupported = errors.New("binary does not support the -metadata-file flag")
+
+// runTestBinary runs the test binary at binPath once, reading its standard input from stdin if it isn't nil.
+// If useMetadataFile is set, the binary is asked to write its metadata to a file rather than printing it.
+func (c *CoverageCollector) runTestBinary(ctx context.Context, cfg runConfig, binPath string, mainTestName string, env []string, args []string, stdin io.Reader, useMetadataFile bool) (*RunResult, error) {
+	run, err := c.newTestRun(cfg, binPath, mainTestName, env, args, useMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	defer run.cleanup()
+	if stdin != nil {
+		run.cmd.Stdin = stdin
+	}
+	for _, cmdFunc := range cfg.preCmdFuncs {
+		if err := cmdFunc(run.cmd); err != nil {
+			return nil, err
+		}
+	}
+	output, runErr := runCmd(ctx, run.cmd, c.TerminationGracePeriod, cfg.combineOutput)
+	if err := c.checkMetadataFileSupport(run, output, runErr); err != nil {
+		return nil, err
+	}
+	return c.finishTestRun(cfg, run, output, runErr)
+}
+
+// checkMetadataFileSupport records whether the binary of run, which has exited with runErr, accepted the
+// -metadata-file flag. It returns errMetadataFileUnsupported if the binary exited with a usage error because of it.
+func (c *CoverageCollector) checkMetadataFileSupport(run *testRun, output *capturedOutput, runErr error) error {
+	if run.metadataFile == nil {
+		return nil
+	}
+	exitError, ok := runErr.(*exec.ExitError)
+	if ok && exitError.ExitCode() == 2 && strings.Contains(output.combined(), "flag provided but not defined: -metadata-file") {
+		c.setSupportsMetadataFile(run.binPath, false)
+		return errMetadataFileUnsupported
+	}
+	if info, err := run.metadataFile.Stat(); err == nil && info.Size() > 0 {
+		c.setSupportsMetadataFile(run.binPath, true)
+	}
+	return nil
+}
+
+// supportsMetadataFile reports whether the test binary at binPath is expected to accept -metadata-file, and whether
+// that is known from an earlier run. Binaries that haven't been run yet are assumed to accept it.
+func (c *CoverageCollector) supportsMetadataFile(binPath string) (supported bool, known bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	supported, known = c.metadataFileSupport[binPath]
+	return supported || !known, known
+}
+
+func (c *CoverageCollector) setSupportsMetadataFile(binPath string, supported bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadataFileSupport == nil {
+		c.metadataFileSupport = make(map[string]bool)
+	}
+	c.metadataFileSupport[binPath] = supported
+}
+
+// Exec is like Run, but an unsuccessful exit of the binary is not an error: the exit code, and the signal if the
+// binary was killed, are reported in the RunResult along with the output. Use ExpectSuccess to make an
+// unsuccessful exit an error again. Errors running the binary or collecting its coverage are still returned.
+func (c *CoverageCollector) Exec(ctx context.Context, binPath string, mainTestName string, env []string, args []string, options ...CoverageCollectorOption) (*RunResult, error) {
+	result, err := c.Run(ctx, binPath, mainTestName, env, args, options...)
+	var exitError *exec.ExitError
+	if err != nil && (result == nil || !errors.As(err, &exitError)) {
+		return result, err
+	}
+	// Run has already rejected options that can't be passed to a single run.
+	if cfg, _ := c.runConfigWith(options); cfg.expectSuccess && (result.ExitCode != 0 || result.Signal != nil) {
+		format := "unsuccessful exit by command \"%s\"\nExit code: %d\nOutput:\n%s"
+		return result, errors.Errorf(format, binPath, result.ExitCode, result.Combined)
+	}
+	return result, nil
+}
+
+// ExpectSuccess makes Exec return an error, along with the RunResult, if the binary exits with a nonzero exit code
+// or is killed by a signal.
+func ExpectSuccess() CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.expectSuccess = true
+	}
+}
+
+// testRun is a single run of a test binary built with "go test -c", together with the temporary files it uses.
+type testRun struct {
+	binPath      string
+	args         []string
+	cmd          *exec.Cmd
+	argsFilename string
+	metadataFile *os.File
+	// If the binary calls os.Exit, the test framework never writes the coverage profile,
+	// but the Go runtime writes the coverage counters to coverDir, the binary's GOCOVERDIR.
+	coverDir     string
+	keepCoverDir bool
+	tempCovFile  *os.File
+	keepCovFile  bool
+	sandbox      *sandbox
+}
+
+// newTestRun creates the temporary files for a run of the test binary at binPath, and the command running it.
+// The metadata file is only created if useMetadataFile is set.
+func (c *CoverageCollector) newTestRun(cfg runConfig, binPath string, mainTestName string, env []string, args []string, useMetadataFile bool) (_ *testRun, err error) {
+	run := &testRun{binPath: binPath, args: args}
+	// err is named so that the files created so far are removed if a later step fails.
+	defer func() {
 		if err != nil {
-			return "", -1, err
+			run.cleanup()
 		}
-		binArgs = fmt.Sprintf("-test.run=^%s$ -test.coverprofile=%s -args-file=%s", mainTestName, tempCovFile.Name(), c.tmpArgsFile.Name())
-	} else {
-		binArgs = fmt.Sprintf("-test.run=^%s$ -args-file=%s", mainTestName, c.tmpArgsFile.Name())
+	}()
+	// Binaries that don't accept -metadata-file were built before args files were terminated with argTerminator.
+	run.argsFilename, err = writeArgsFile(args, !useMetadataFile)
+	if err != nil {
+		return nil, err
 	}
-	cmd := exec.Command(binPath, strings.Split(binArgs, " ")...)
-	cmd.Env = append(os.Environ(), env...)
-	for _, cmdFunc := range c.preCmdFuncs {
-		if err := cmdFunc(cmd); err != nil {
-			return "", -1, err
+	if useMetadataFile {
+		run.metadataFile, err = os.CreateTemp("", defaultTmpMetadataFilePrefix)
+		if err != nil {
+			return nil, err
 		}
 	}
-	combinedOutput, err := cmd.CombinedOutput()
-	binOutput := string(combinedOutput)
+	run.coverDir, err = os.MkdirTemp("", defaultTmpCoverDirPrefix)
 	if err != nil {
-		if tempCovFile != nil {
-			removeTempCoverageFile(tempCovFile.Name())
+		return nil, err
+	}
+	if mainTestName == "" {
+		mainTestName = DefaultMainTestName
+	}
+	binArgs := []string{"-test.run=^" + mainTestName + "$"}
+	if c.CollectCoverage {
+		run.tempCovFile, err = os.CreateTemp("", defaultTmpCoverageFilePrefix)
+		if err != nil {
+			return nil, err
 		}
-		// This exit code testing requires 1.12 - https://stackoverflow.com/a/55055100/337735.
-		if exitError, ok := err.(*exec.ExitError); ok {
-			binExitCode := exitError.ExitCode()
+		binArgs = append(binArgs, "-test.coverprofile="+run.tempCovFile.Name())
+	}
+	binArgs = append(binArgs, "-args-file="+run.argsFilename)
+	if run.metadataFile != nil {
+		binArgs = append(binArgs, "-metadata-file="+run.metadataFile.Name())
+	}
+	run.sandbox, err = cfg.newSandbox()
+	if err != nil {
+		return nil, err
+	}
+	run.cmd = exec.Command(binPath, binArgs...)
+	run.cmd.Env = cfg.commandEnv(run.sandbox, env, run.coverDir)
+	if err := cfg.setupDir(run.cmd, run.sandbox); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// cleanup removes the temporary files of run, except for the coverage data handed over to the collector.
+func (r *testRun) cleanup() {
+	if r.tempCovFile != nil && !r.keepCovFile {
+		_ = r.tempCovFile.Close()
+		removeTempCoverageFile(r.tempCovFile.Name())
+	}
+	if r.argsFilename != "" {
+		removeTempArgsFile(r.argsFilename)
+	}
+	if r.metadataFile != nil {
+		removeTempMetadataFile(r.metadataFile)
+	}
+	if r.coverDir != "" && !r.keepCoverDir {
+		removeTempCoverDir(r.coverDir)
+	}
+	r.sandbox.remove()
+}
+
+// finishTestRun collects the metadata and coverage of a test binary that has exited with runErr.
+func (c *CoverageCollector) finishTestRun(cfg runConfig, run *testRun, output *capturedOutput, runErr error) (*RunResult, error) {
+	var metadata *testMetadata
+	var err error
+	if run.metadataFile != nil {
+		metadata, err = readMetadata(run.metadataFile)
+	}
+	if err != nil {
+		if errors.Is(err, ErrMissingMetadata) {
+			return nil, &RunError{BinPath: run.binPath, Output: output.combined(), Err: err}
+		}
+		return nil, err
+	}
+	// This exit code testing requires 1.12 - https://stackoverflow.com/a/55055100/337735.
+	exitError, isExitError := runErr.(*exec.ExitError)
+	// A binary killed by a signal did not call os.Exit, even if it never finished running f.
+	exitedEarly := metadata != nil && metadata.Running && (runErr == nil || (isExitError && exitError.ExitCode() != -1))
+	if _, ok := runErr.(*TimeoutError); ok {
+		if run.tempCovFile != nil {
+			run.keepCovFile = c.keepPartialCoverageFile(run.tempCovFile, cfg.label)
+			run.keepCoverDir = c.keepPartialCoverDir(run.coverDir, cfg.label)
+		}
+		return nil, runErr
+	}
+	if runErr != nil && !exitedEarly {
+		if isExitError {
+			result := output.result()
+			result.ExitCode, result.Signal = exitError.ExitCode(), exitSignal(exitError)
+			if metadata != nil {
+				result.CoverMode = metadata.CoverMode
+			}
+			run.describe(result)
+			if result.SandboxFiles, err = run.sandbox.files(); err != nil {
+				return nil, err
+			}
 			format := "unsuccessful exit by command \"%s\"\nExit code: %d\nOutput:\n%s"
-			return "", binExitCode, errors.Wrapf(exitError, format, binPath, binExitCode, binOutput)
+			return result, errors.Wrapf(exitError, format, run.binPath, result.ExitCode, result.Combined)
 
 		} else {
 			format := "unexpected error running command \"%s\""
-			return "", -1, errors.Wrapf(err, format, binPath)
+			return nil, errors.Wrapf(runErr, format, run.binPath)
 		}
 	}
-	haveTestsToRun := haveTestsToRun(binOutput)
+	haveTestsToRun := haveTestsToRun(output.combined())
 	if !haveTestsToRun {
-		return "", -1, errors.New(binOutput)
-	}
-	if tempCovFile != nil {
-		c.tmpCoverageFiles = append(c.tmpCoverageFiles, tempCovFile)
+		return nil, errors.New(output.combined())
 	}
-	cmdOutput, coverMode, exitCode := parseCommandOutput(string(combinedOutput))
-	for _, cmdFunc := range c.postCmdFuncs {
-		if e := cmdFunc(cmd, cmdOutput, err); e != nil {
-			return "", -1, e
+	var result *RunResult
+	var coverMode string
+	switch {
+	case exitedEarly:
+		// The binary called os.Exit, so its exit code is the process's exit code.
+		result = output.result()
+		if isExitError {
+			result.ExitCode = exitError.ExitCode()
+		}
+		coverMode = metadata.CoverMode
+	case metadata != nil:
+		result = output.result()
+		result.ExitCode, coverMode = metadata.ExitCode, metadata.CoverMode
+	default:
+		// The binary doesn't support the metadata file, so it printed its metadata instead.
+		if result, coverMode, err = stripMetadata(output); err != nil {
+			return nil, &RunError{BinPath: run.binPath, Output: output.combined(), Err: err}
 		}
 	}
+	// Coverage is only kept once its mode is known to fit the merged profile.
 	if c.CollectCoverage {
-		if c.coverMode == "" {
-			c.coverMode = coverMode
-		}
-		if c.coverMode == "" {
-			panic("coverage mode cannot be empty. test coverage must be enabled when CollectCoverage is set to true")
+		if err := c.checkCoverMode(coverMode); err != nil {
+			return result, &RunError{BinPath: run.binPath, Output: output.combined(), Err: err}
 		}
-		// https://github.com/wadey/gocovmerge/blob/b5bfa59ec0adc420475f97f89b58045c721d761c/gocovmerge.go#L18
-		if c.coverMode != coverMode {
-			panic("cannot merge profiles with different coverage modes")
-		}
-		if c.coverMode != set && c.coverMode != count && c.coverMode != atomic {
-			log.Panicf("unexpected coverage mode \"%s\" encountered. Coverage mode must be set, count, or atomic", c.coverMode)
+	}
+	if run.tempCovFile != nil && exitedEarly {
+		run.keepCoverDir = c.keepPartialCoverDir(run.coverDir, cfg.label)
+	}
+	if run.tempCovFile != nil && !exitedEarly {
+		c.mu.Lock()
+		c.tmpCoverageFiles = append(c.tmpCoverageFiles, run.tempCovFile)
+		c.setRunLabel(run.tempCovFile.Name(), cfg.label)
+		c.mu.Unlock()
+		run.keepCovFile = true
+	}
+	result.Duration, result.CoverMode = output.duration, coverMode
+	run.describe(result)
+	if result.SandboxFiles, err = run.sandbox.files(); err != nil {
+		return nil, err
+	}
+	for _, cmdFunc := range cfg.postCmdFuncs {
+		if e := cmdFunc(run.cmd, result.Combined, nil); e != nil {
+			return nil, e
 		}
 	}
-	return cmdOutput, exitCode, err
+	return result, nil
 }
 
-func (c *CoverageCollector) writeArgs(args []string) error {
-	err := c.tmpArgsFile.Truncate(0)
+// describe records the arguments, environment and kept coverage of run in result.
+func (r *testRun) describe(result *RunResult) {
+	result.Args, result.Env = r.args, r.cmd.Env
+	switch {
+	case r.keepCovFile:
+		result.CoverProfile = r.tempCovFile.Name()
+	case r.keepCoverDir:
+		result.CoverProfile = r.coverDir
+	}
+}
+
+// checkCoverMode checks that coverMode, reported by a run, can be merged with the coverage of previous runs,
+// and records the mode the merged coverage will have.
+func (c *CoverageCollector) checkCoverMode(coverMode string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if coverMode == "" {
+		return errorOf(ErrInvalidCoverMode, "coverage mode cannot be empty. test coverage must be enabled when CollectCoverage is set to true")
+	}
+	if !isCoverMode(coverMode) {
+		return errorOf(ErrInvalidCoverMode, "unexpected coverage mode \"%s\" encountered. Coverage mode must be set, count, or atomic", coverMode)
+	}
+	mode, err := c.mergedCoverMode(coverMode)
 	if err != nil {
 		return err
 	}
-	_, err = c.tmpArgsFile.Seek(0, 0)
+	c.coverMode = mode
+	return nil
+}
+
+// mergedCoverMode returns the mode of the coverage of previous runs merged with coverage in coverMode.
+// If a target mode is set, coverMode must be convertible to it. c.mu must be held.
+func (c *CoverageCollector) mergedCoverMode(coverMode string) (string, error) {
+	if c.targetCoverMode != "" && !canConvertMode(coverMode, c.targetCoverMode) {
+		return "", errorOf(ErrCoverModeMismatch, "cannot convert coverage mode \"%s\" to the target mode \"%s\"", coverMode, c.targetCoverMode)
+	}
+	if c.coverMode == "" {
+		return coverMode, nil
+	}
+	return mergedMode(c.coverMode, coverMode)
+}
+
+// runConfigWith returns the collector's run settings with options applied on top.
+// Options are applied to a scratch collector, so that they don't leak into other runs. Options that configure
+// the collector as a whole rather than a run, which would be lost with the scratch collector, are an error.
+func (c *CoverageCollector) runConfigWith(options []CoverageCollectorOption) (runConfig, error) {
+	scratch := &CoverageCollector{runConfig: c.runConfig}
+	for _, option := range options {
+		option(scratch)
+	}
+	if scratch.targetCoverMode != "" || len(scratch.includedProfiles) > 0 || !scratch.thresholds.isZero() {
+		return runConfig{}, errors.New("TargetCoverMode, IncludeProfiles and the coverage thresholds must be passed to NewCoverageCollector, not to a single run")
+	}
+	return scratch.runConfig, nil
+}
+
+// setStdin connects cmd's standard input to the reader configured with a Stdin option.
+// The returned function closes the reader and must be called once cmd has exited.
+func (cfg runConfig) setStdin(cmd *exec.Cmd) (func(), error) {
+	stdin, closeStdin, err := cfg.openStdin()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	return closeStdin, nil
+}
+
+// openStdin opens the reader configured with a Stdin option, or returns a nil reader if there is none.
+// The returned function closes the reader and must be called once the binary reading it has exited.
+func (cfg runConfig) openStdin() (io.Reader, func(), error) {
+	if cfg.stdin == nil {
+		return nil, func() {}, nil
 	}
-	argStr := strings.Join(args, "\n")
-	_, err = c.tmpArgsFile.WriteAt([]byte(argStr), 0)
+	stdin, err := cfg.stdin()
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	closeStdin := func() { _ = stdin.Close() }
+	// exec copies readers other than *os.File to the binary in a goroutine, and Wait doesn't return until the copy
+	// reaches EOF, even after the binary has exited. A file that is kept open, like a terminal, would block the run.
+	if shared, ok := stdin.(sharedFile); ok {
+		return shared.File, closeStdin, nil
+	}
+	return stdin, closeStdin, nil
+}
+
+// acquire blocks until fewer than MaxConcurrency binaries are running, or ctx ends.
+func (c *CoverageCollector) acquire(ctx context.Context) error {
+	if c.sem == nil {
+		return nil
+	}
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "error waiting to run binary")
+	}
+}
+
+func (c *CoverageCollector) release() {
+	if c.sem != nil {
+		<-c.sem
 	}
-	return err
 }
 
-func parseCommandOutput(output string) (cmdOutput string, coverMode string, exitCode int) {
+// keepPartialCoverageFile keeps the coverage profile of a binary that was stopped because its context ended,
+// if the binary managed to write it. It reports whether file was kept.
+func (c *CoverageCollector) keepPartialCoverageFile(file *os.File, label string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := parseProfile(file)
+	if err != nil || len(p.files) == 0 {
+		return false
+	}
+	mode, err := c.mergedCoverMode(p.mode)
+	if err != nil {
+		return false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	c.coverMode = mode
+	c.tmpCoverageFiles = append(c.tmpCoverageFiles, file)
+	c.setRunLabel(file.Name(), label)
+	return true
+}
+
+// setRunLabel records the label of the run that wrote the temp coverage file or cover dir at path.
+// c.mu must be held.
+func (c *CoverageCollector) setRunLabel(path string, label string) {
+	if label == "" {
+		return
+	}
+	if c.runLabels == nil {
+		c.runLabels = make(map[string]string)
+	}
+	c.runLabels[path] = label
+}
+
+// writeArgsFile writes args to a new temporary file, so that concurrent runs don't share an args file.
+// If legacy is set, args are written in the newline-separated format of earlier versions of bincover.
+func writeArgsFile(args []string, legacy bool) (string, error) {
+	file, err := os.CreateTemp("", defaultTmpArgsFilePrefix)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating temporary args file")
+	}
+	encode := encodeArgs
+	if legacy {
+		encode = encodeLegacyArgs
+	}
+	_, err = file.WriteString(encode(args))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTempArgsFile(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func parseCommandOutput(output string) (cmdOutput string, coverMode string, exitCode int, err error) {
 	startIndex := strings.Index(output, startOfMetadataMarker)
 	if startIndex == -1 {
-		panic("metadata start marker is unexpectedly missing")
+		return "", "", 0, errorOf(ErrMissingMetadata, "metadata start marker is unexpectedly missing")
 	}
 	endIndex := strings.Index(output, endOfMetadataMarker)
 	if endIndex == -1 {
-		panic("metadata end marker is unexpectedly missing")
+		return "", "", 0, errorOf(ErrMissingMetadata, "metadata end marker is unexpectedly missing")
 	}
 	cmdOutput = output[:startIndex]
 	tail := output[startIndex+len(startOfMetadataMarker) : endIndex]
 	// Trim extra newline after cmd output.
 	metadataStr := strings.TrimSpace(tail)
 	var metadata testMetadata
-	err := json.Unmarshal([]byte(metadataStr), &metadata)
+	if err := json.Unmarshal([]byte(metadataStr), &metadata); err != nil {
+		return "", "", 0, errorOf(ErrMissingMetadata, "error unmarshalling testMetadata struct from RunTest: %s", err)
+	}
+	return cmdOutput, metadata.CoverMode, metadata.ExitCode, nil
+}
+
+// readMetadata reads the metadata RunTest wrote to file. It returns nil if RunTest didn't write any.
+func readMetadata(file *os.File) (*testMetadata, error) {
+	buf, err := io.ReadAll(file)
 	if err != nil {
-		panic("error unmarshalling testMetadata struct from RunTest")
+		return nil, errors.Wrap(err, "error reading temp metadata file")
 	}
-	return cmdOutput, metadata.CoverMode, metadata.ExitCode
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	var metadata testMetadata
+	if err := json.Unmarshal(buf, &metadata); err != nil {
+		return nil, errorOf(ErrMissingMetadata, "error unmarshalling testMetadata struct from RunTest: %s", err)
+	}
+	return &metadata, nil
+}
+
+// stripMetadata removes the metadata printed by RunTest, and everything printed after it, from the stream that carries it.
+// It returns the remaining output with the exit code reported by RunTest, along with the reported coverage mode.
+func stripMetadata(output *capturedOutput) (result *RunResult, coverMode string, err error) {
+	result = &RunResult{Stdout: output.stdout, Stderr: output.stderr}
+	metadataStream := &result.Stdout
+	if !strings.Contains(output.stdout, startOfMetadataMarker) && strings.Contains(output.stderr, startOfMetadataMarker) {
+		metadataStream = &result.Stderr
+	}
+	*metadataStream, coverMode, result.ExitCode, err = parseCommandOutput(*metadataStream)
+	if err != nil {
+		return nil, "", err
+	}
+	result.Combined = output.interleave(len(result.Stdout), len(result.Stderr))
+	return result, coverMode, nil
 }
 
 func (c *CoverageCollector) removeTempFiles() {
 	for _, file := range c.tmpCoverageFiles {
 		removeTempCoverageFile(file.Name())
 	}
-	if c.tmpArgsFile != nil {
-		err := os.Remove(c.tmpArgsFile.Name())
-		if err != nil {
-			log.Printf("error removing temp arg file: %s\n", err)
-		}
+	for _, dir := range c.tmpCoverDirs {
+		removeTempCoverDir(dir)
+	}
+	c.tmpCoverageFiles, c.tmpCoverDirs, c.profiles, c.runLabels = nil, nil, nil, nil
+}
+
+func removeTempArgsFile(name string) {
+	err := os.Remove(name)
+	if err != nil {
+		log.Printf("error removing temp arg file: %s\n", err)
+	}
+}
+
+func removeTempMetadataFile(file *os.File) {
+	_ = file.Close()
+	err := os.Remove(file.Name())
+	if err != nil {
+		log.Printf("error removing temp metadata file: %s\n", err)
 	}
 }
 
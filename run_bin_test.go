@@ -2,13 +2,19 @@ package bincover
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -20,17 +26,27 @@ const (
 )
 
 func TestMain(m *testing.M) {
+	RegisterUpdateFlag()
 	// Build necessary binaries before executing unit tests.
-	buildTestCmd := exec.Command("go", []string{"test", "./test_bins", "-tags", "testrunmain", "-coverpkg=./...", "-c", "-o", "set_covermode"}...)
-	output, err := buildTestCmd.CombinedOutput()
-	if err != nil {
-		log.Println(output)
-		panic(err)
+	testBins := map[string]string{
+		"set_covermode": "./test_bins",
+		"os_exit":       "./test_bins/os_exit",
+		"legacy":        "./test_bins/legacy",
+	}
+	for binName, pkg := range testBins {
+		buildTestCmd := exec.Command("go", []string{"test", pkg, "-tags", "testrunmain", "-coverpkg=./...", "-c", "-o", binName}...)
+		output, err := buildTestCmd.CombinedOutput()
+		if err != nil {
+			log.Println(output)
+			panic(err)
+		}
 	}
 	exitCode := m.Run()
-	err = os.Remove("set_covermode")
-	if err != nil {
-		panic(err)
+	for binName := range testBins {
+		err := os.Remove(binName)
+		if err != nil {
+			panic(err)
+		}
 	}
 	os.Exit(exitCode)
 }
@@ -114,23 +130,69 @@ func TestCoverageCollector_TearDown(t *testing.T) {
 				MergedCoverageFilename: "temp_merged.out",
 				CollectCoverage:        true,
 				tmpCoverageFiles: func() []*os.File {
-					f1 := tempFileWithContent(t, "mode: set\nfirst file\n")
-					f2 := tempFileWithContent(t, "mode: set\nsecond file\n")
-					f3 := tempFileWithContent(t, "mode: set\nthird file\n")
+					f1 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 1\n")
+					f2 := tempFileWithContent(t, "mode: set\nb.go:1.1,2.2 1 0\n")
+					f3 := tempFileWithContent(t, "mode: set\nc.go:1.1,2.2 1 1\n")
+					return []*os.File{f1, f2, f3}
+				}(),
+				coverMode: "set",
+			},
+			mergedFileContents: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 0\nc.go:1.1,2.2 1 1\n",
+			wantErr:            false,
+		},
+		{
+			name: "succeed tearing down with repeated blocks in set mode",
+			fields: fields{
+				MergedCoverageFilename: "temp_merged.out",
+				CollectCoverage:        true,
+				tmpCoverageFiles: func() []*os.File {
+					f1 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\n")
+					f2 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 0\na.go:3.1,4.2 2 0\n")
+					f3 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 1\n")
 					return []*os.File{f1, f2, f3}
 				}(),
 				coverMode: "set",
 			},
-			mergedFileContents: "mode: set\nfirst file\nsecond file\nthird file",
+			mergedFileContents: "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 1\n",
+			wantErr:            false,
+		},
+		{
+			name: "succeed tearing down with repeated blocks in count mode",
+			fields: fields{
+				MergedCoverageFilename: "temp_merged.out",
+				CollectCoverage:        true,
+				tmpCoverageFiles: func() []*os.File {
+					f1 := tempFileWithContent(t, "mode: count\na.go:3.1,4.2 2 0\na.go:1.1,2.2 1 3\n")
+					f2 := tempFileWithContent(t, "mode: count\na.go:1.1,2.2 1 4\na.go:3.1,4.2 2 5\n")
+					return []*os.File{f1, f2}
+				}(),
+				coverMode: "count",
+			},
+			mergedFileContents: "mode: count\na.go:1.1,2.2 1 7\na.go:3.1,4.2 2 5\n",
 			wantErr:            false,
 		},
+		{
+			name: "fail tearing down with overlapping blocks",
+			fields: fields{
+				MergedCoverageFilename: "temp_merged.out",
+				CollectCoverage:        true,
+				tmpCoverageFiles: func() []*os.File {
+					f1 := tempFileWithContent(t, "mode: set\na.go:1.1,3.2 1 1\n")
+					f2 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 1\n")
+					return []*os.File{f1, f2}
+				}(),
+				coverMode: "set",
+			},
+			wantErr:    true,
+			errMessage: "error merging coverage profiles: overlapping blocks a.go:1.1,2.2 and a.go:1.1,3.2",
+		},
 		{
 			name: "fail tearing down with missing coverage mode",
 			fields: fields{
 				MergedCoverageFilename: "temp_merged.out",
 				CollectCoverage:        true,
 				tmpCoverageFiles: func() []*os.File {
-					f1 := tempFileWithContent(t, "mode: set\nfirst file\n")
+					f1 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 1\n")
 					missingHeaderFile := tempFileWithContent(t, "second file\n")
 					return []*os.File{f1, missingHeaderFile}
 				}(),
@@ -158,10 +220,9 @@ func TestCoverageCollector_TearDown(t *testing.T) {
 				MergedCoverageFilename: "inval?df!l3Nam3/.%",
 				CollectCoverage:        true,
 				tmpCoverageFiles: func() []*os.File {
-					f1 := tempFileWithContent(t, "mode: set\nfirst file\n")
-					f2 := tempFileWithContent(t, "mode: set\nsecond file\n")
-					f3 := tempFileWithContent(t, "mode: set\nthird file\n")
-					return []*os.File{f1, f2, f3}
+					f1 := tempFileWithContent(t, "mode: set\na.go:1.1,2.2 1 1\n")
+					f2 := tempFileWithContent(t, "mode: set\nb.go:1.1,2.2 1 1\n")
+					return []*os.File{f1, f2}
 				}(),
 			},
 			wantErr:            true,
@@ -202,7 +263,7 @@ func TestCoverageCollector_removeTempFiles(t *testing.T) {
 	tests := []struct {
 		name             string
 		tmpCoverageFiles []*os.File
-		tmpArgsFile      *os.File
+		tmpCoverDirs     []string
 		stdErrOutputFmt  string
 	}{
 		{
@@ -210,8 +271,10 @@ func TestCoverageCollector_removeTempFiles(t *testing.T) {
 			tmpCoverageFiles: func() []*os.File {
 				return []*os.File{tempFile(t)}
 			}(),
-			tmpArgsFile: func() *os.File {
-				return tempFile(t)
+			tmpCoverDirs: func() []string {
+				dir, err := os.MkdirTemp("", "")
+				require.NoError(t, err)
+				return []string{dir}
 			}(),
 			stdErrOutputFmt: "",
 		},
@@ -221,17 +284,14 @@ func TestCoverageCollector_removeTempFiles(t *testing.T) {
 				f := removedTempFile(t)
 				return []*os.File{f}
 			}(),
-			tmpArgsFile: func() *os.File {
-				return removedTempFile(t)
-			}(),
-			stdErrOutputFmt: ".*error removing.*\n.*error removing temp arg file.*",
+			stdErrOutputFmt: ".*error removing temp coverage file.*",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &CoverageCollector{}
 			c.tmpCoverageFiles = tt.tmpCoverageFiles
-			c.tmpArgsFile = tt.tmpArgsFile
+			c.tmpCoverDirs = tt.tmpCoverDirs
 			var buf bytes.Buffer
 			log.SetOutput(&buf)
 			c.removeTempFiles()
@@ -241,8 +301,10 @@ func TestCoverageCollector_removeTempFiles(t *testing.T) {
 				_, err := os.Stat(file.Name())
 				require.True(t, os.IsNotExist(err))
 			}
-			_, err := os.Stat(c.tmpArgsFile.Name())
-			require.True(t, os.IsNotExist(err))
+			for _, dir := range c.tmpCoverDirs {
+				_, err := os.Stat(dir)
+				require.True(t, os.IsNotExist(err))
+			}
 		})
 	}
 }
@@ -288,26 +350,22 @@ func Test_parseCommandOutput(t *testing.T) {
 		wantCmdOutput string
 		wantCoverMode string
 		wantExitCode  int
-		wantPanic     bool
-		panicMessage  string
+		errMessage    string
 	}{
 		{
-			name:         "panic if metadata start marker is missing",
-			args:         args{output: ""},
-			wantPanic:    true,
-			panicMessage: "metadata start marker is unexpectedly missing",
+			name:       "fail if metadata start marker is missing",
+			args:       args{output: ""},
+			errMessage: "metadata start marker is unexpectedly missing",
 		},
 		{
-			name:         "panic if metadata end marker is missing",
-			args:         args{output: startOfMetadataMarker},
-			wantPanic:    true,
-			panicMessage: "metadata end marker is unexpectedly missing",
+			name:       "fail if metadata end marker is missing",
+			args:       args{output: startOfMetadataMarker},
+			errMessage: "metadata end marker is unexpectedly missing",
 		},
 		{
-			name:         "panic if error occurs while unmarshalling testMetadata",
-			args:         args{output: startOfMetadataMarker + "invalid" + endOfMetadataMarker},
-			wantPanic:    true,
-			panicMessage: "error unmarshalling testMetadata struct from RunTest",
+			name:       "fail if error occurs while unmarshalling testMetadata",
+			args:       args{output: startOfMetadataMarker + "invalid" + endOfMetadataMarker},
+			errMessage: "error unmarshalling testMetadata struct from RunTest: invalid character 'i' looking for beginning of value",
 		},
 		{
 			name: "succeed parsing command output",
@@ -321,11 +379,13 @@ func Test_parseCommandOutput(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.wantPanic {
-				require.PanicsWithValue(t, tt.panicMessage, func() { parseCommandOutput(tt.args.output) })
+			gotCmdOutput, gotCoverMode, gotExitCode, err := parseCommandOutput(tt.args.output)
+			if tt.errMessage != "" {
+				require.ErrorIs(t, err, ErrMissingMetadata)
+				require.EqualError(t, err, tt.errMessage)
 				return
 			}
-			gotCmdOutput, gotCoverMode, gotExitCode := parseCommandOutput(tt.args.output)
+			require.NoError(t, err)
 			if gotCmdOutput != tt.wantCmdOutput {
 				t.Errorf("parseCommandOutput() gotCmdOutput = %v, want %v", gotCmdOutput, tt.wantCmdOutput)
 			}
@@ -339,63 +399,49 @@ func Test_parseCommandOutput(t *testing.T) {
 	}
 }
 
-func TestCoverageCollector_writeArgs(t *testing.T) {
-	type fields struct {
-		MergedCoverageFilename string
-		CollectCoverage        bool
-		tmpArgsFile            *os.File
-		coverMode              string
-		tmpCoverageFiles       []*os.File
-		setupFinished          bool
-	}
-	type args struct {
-		args []string
-	}
+func Test_writeArgsFile(t *testing.T) {
 	tests := []struct {
 		name               string
-		fields             fields
-		args               args
+		tmpDir             string
+		args               []string
+		legacy             bool
 		wantErr            bool
 		wantArgFileContent string
 	}{
 		{
-			name: "fail when writing args to closed file",
-			fields: fields{
-				tmpArgsFile: func() *os.File {
-					f := tempFile(t)
-					require.NoError(t, f.Close())
-					return f
-				}(),
-			},
+			name:    "fail when temp dir does not exist",
+			tmpDir:  "nonexistent-dir",
 			wantErr: true,
 		},
 		{
-			name: "succeed writing args",
-			fields: fields{
-				tmpArgsFile: func() *os.File {
-					return tempFile(t)
-				}(),
-			},
-			args:               args{args: []string{"first", "second", "third"}},
+			name:               "succeed writing args",
+			args:               []string{"first", "second", "third"},
+			wantArgFileContent: "first\x00second\x00third\x00",
+		},
+		{
+			name:               "succeed writing args with newlines, spaces and empty args",
+			args:               []string{"multi\nline", " padded ", ""},
+			wantArgFileContent: "multi\nline\x00 padded \x00\x00",
+		},
+		{
+			name:               "succeed writing args for legacy binary",
+			args:               []string{"first", "second", "third"},
+			legacy:             true,
 			wantArgFileContent: "first\nsecond\nthird",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer os.Remove(tt.fields.tmpArgsFile.Name())
-			c := &CoverageCollector{
-				MergedCoverageFilename: tt.fields.MergedCoverageFilename,
-				CollectCoverage:        tt.fields.CollectCoverage,
-				tmpArgsFile:            tt.fields.tmpArgsFile,
-				coverMode:              tt.fields.coverMode,
-				tmpCoverageFiles:       tt.fields.tmpCoverageFiles,
-				setupFinished:          tt.fields.setupFinished,
+			if tt.tmpDir != "" {
+				t.Setenv("TMPDIR", tt.tmpDir)
 			}
-			if err := c.writeArgs(tt.args.args); (err != nil) != tt.wantErr {
-				t.Errorf("writeArgs() error = %v, wantErr %v", err, tt.wantErr)
+			name, err := writeArgsFile(tt.args, tt.legacy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("writeArgsFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !tt.wantErr {
-				buf, err := io.ReadAll(c.tmpArgsFile)
+				defer os.Remove(name)
+				buf, err := os.ReadFile(name)
 				require.NoError(t, err)
 				require.Equal(t, tt.wantArgFileContent, string(buf))
 			}
@@ -460,6 +506,45 @@ func TestPreExec(t *testing.T) {
 	}
 }
 
+func TestPreExec_perRun(t *testing.T) {
+	var calls []string
+	record := func(name string) PreCmdFunc {
+		return func(cmd *exec.Cmd) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	c := NewCoverageCollector("", false, PreExec(record("collector")))
+	require.NoError(t, c.Setup())
+	// Unlike earlier versions, PreExec passed to RunBinary only replaces the collector's functions for that run.
+	_, _, err := c.RunBinary("./test_bins/read_stdin.sh", "", nil, nil, PreExec(record("run")))
+	require.NoError(t, err)
+	_, _, err = c.RunBinary("./test_bins/read_stdin.sh", "", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"run", "collector"}, calls)
+}
+
+func TestCoverageCollector_RunBinary_collectorOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		option CoverageCollectorOption
+	}{
+		{name: "fail passing TargetCoverMode to a run", option: TargetCoverMode(count)},
+		{name: "fail passing IncludeProfiles to a run", option: IncludeProfiles("unit.out")},
+		{name: "fail passing MinCoverage to a run", option: MinCoverage(50)},
+		{name: "fail passing MinPackageCoverage to a run", option: MinPackageCoverage("github.com/confluentinc/bincover", 50)},
+		{name: "fail passing MinFileCoverage to a run", option: MinFileCoverage("github.com/confluentinc/bincover/run_bin.go", 50)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false)
+			require.NoError(t, c.Setup())
+			_, _, err := c.RunBinary("./test_bins/read_stdin.sh", "", nil, nil, tt.option)
+			require.EqualError(t, err, "TargetCoverMode, IncludeProfiles and the coverage thresholds must be passed to NewCoverageCollector, not to a single run")
+		})
+	}
+}
+
 func TestPostExec(t *testing.T) {
 	type args struct {
 		binPath      string
@@ -537,15 +622,17 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 		wantExitCode int
 		wantErr      bool
 		errMessage   string
-		wantPanic    bool
-		panicMessage string
-		skipSetup    bool
-		cmdFuncs     []CoverageCollectorOption
+		// wantErrIs is the error the returned error must wrap. Errors matching it are not checked further.
+		wantErrIs error
+		skipSetup bool
+		cmdFuncs  []CoverageCollectorOption
 	}{
 		{
-			name:         "panic if Setup not called",
-			wantPanic:    true,
-			panicMessage: "RunBinary called before Setup",
+			name:         "fail if Setup not called",
+			wantErr:      true,
+			wantErrIs:    ErrNotSetUp,
+			errMessage:   "RunBinary called before Setup",
+			wantExitCode: -1,
 			skipSetup:    true,
 		},
 		{
@@ -603,7 +690,7 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 			wantExitCode: 1,
 		},
 		{
-			name: "panic running binary which outputs empty coverage mode",
+			name: "fail running binary which outputs empty coverage mode",
 			args: args{
 				binPath:      "./test_bins/empty_covermode.sh",
 				mainTestName: "",
@@ -614,11 +701,13 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 				MergedCoverageFilename: "temp_coverage.out",
 				CollectCoverage:        true,
 			},
-			wantPanic:    true,
-			panicMessage: "coverage mode cannot be empty. test coverage must be enabled when CollectCoverage is set to true",
+			wantErr:      true,
+			wantErrIs:    ErrInvalidCoverMode,
+			errMessage:   "command \"./test_bins/empty_covermode.sh\": coverage mode cannot be empty. test coverage must be enabled when CollectCoverage is set to true\nOutput:\nHello world\n" + startOfMetadataMarker + "\n{\"cover_mode\":\"\",\"exit_code\":1}\n" + endOfMetadataMarker + "\n",
+			wantExitCode: 1,
 		},
 		{
-			name: "panic running binary which outputs different coverage mode",
+			name: "succeed running binary which outputs different coverage mode",
 			args: args{
 				binPath:      "./set_covermode",
 				mainTestName: "TestRunMain",
@@ -630,11 +719,11 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 				CollectCoverage:        true,
 				coverMode:              "atomic",
 			},
-			wantPanic:    true,
-			panicMessage: "cannot merge profiles with different coverage modes",
+			wantOutput:   "Hello world\n",
+			wantExitCode: 1,
 		},
 		{
-			name: "panic running binary which outputs unexpected coverage mode",
+			name: "fail running binary which outputs unexpected coverage mode",
 			args: args{
 				binPath:      "./test_bins/unexpected_covermode.sh",
 				mainTestName: "",
@@ -645,8 +734,10 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 				MergedCoverageFilename: "temp_coverage.out",
 				CollectCoverage:        true,
 			},
-			wantPanic:    true,
-			panicMessage: "unexpected coverage mode \"evil\" encountered. Coverage mode must be set, count, or atomic",
+			wantErr:      true,
+			wantErrIs:    ErrInvalidCoverMode,
+			errMessage:   "unexpected coverage mode \"evil\" encountered. Coverage mode must be set, count, or atomic",
+			wantExitCode: 1,
 		},
 		{
 			name: "fail running binary if there are no tests to run",
@@ -732,16 +823,13 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 			if !tt.skipSetup {
 				require.NoError(t, c.Setup())
 			}
-			if tt.wantPanic {
-				require.PanicsWithValue(t,
-					tt.panicMessage,
-					func() {
-						_, _, _ = c.RunBinary(tt.args.binPath, tt.args.mainTestName, tt.args.env, tt.args.args, tt.cmdFuncs...)
-					},
-				)
+			gotOutput, gotExitCode, err := c.RunBinary(tt.args.binPath, tt.args.mainTestName, tt.args.env, tt.args.args, tt.cmdFuncs...)
+			if tt.wantErrIs != nil {
+				require.ErrorIs(t, err, tt.wantErrIs)
+				require.Contains(t, err.Error(), tt.errMessage)
+				require.Equal(t, tt.wantExitCode, gotExitCode)
 				return
 			}
-			gotOutput, gotExitCode, err := c.RunBinary(tt.args.binPath, tt.args.mainTestName, tt.args.env, tt.args.args, tt.cmdFuncs...)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("RunBinary() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -766,6 +854,329 @@ func TestCoverageCollector_RunBinary(t *testing.T) {
 	}
 }
 
+func TestCoverageCollector_RunBinary_outputOrder(t *testing.T) {
+	var want strings.Builder
+	for i := 1; i <= 300; i++ {
+		fmt.Fprintf(&want, "out %d\nerr %d\n", i, i)
+	}
+	c := NewCoverageCollector("", false)
+	require.NoError(t, c.Setup())
+	for i := 0; i < 5; i++ {
+		output, exitCode, err := c.RunBinary("./test_bins/alternate_streams.sh", "", nil, nil)
+		require.NoError(t, err)
+		require.Zero(t, exitCode)
+		require.Equal(t, want.String(), output)
+	}
+}
+
+func TestCoverageCollector_Run(t *testing.T) {
+	tests := []struct {
+		name          string
+		binPath       string
+		mainTestName  string
+		wantStdout    string
+		wantStderr    string
+		wantExitCode  int
+		wantErr       bool
+		wantNilResult bool
+	}{
+		{
+			name:         "succeed separating stdout and stderr",
+			binPath:      "./test_bins/stdout_stderr.sh",
+			wantStdout:   "To stdout\n",
+			wantStderr:   "To stderr\n",
+			wantExitCode: 3,
+		},
+		{
+			name:         "succeed stripping metadata from stderr",
+			binPath:      "./test_bins/metadata_stderr.sh",
+			wantStdout:   "To stdout\n",
+			wantStderr:   "",
+			wantExitCode: 3,
+		},
+		{
+			name:         "succeed returning output unchanged when metadata is written to file",
+			binPath:      "./test_bins/metadata_file.sh",
+			wantStdout:   "START_BINCOVER_METADATA\nPrinted by the binary itself\nEND_BINCOVER_METADATA\n",
+			wantExitCode: 2,
+		},
+		{
+			name:         "succeed running instrumented binary",
+			binPath:      "./set_covermode",
+			mainTestName: "TestRunMain",
+			wantStdout:   helloWorldOutput,
+			wantExitCode: 1,
+		},
+		{
+			name:         "fail running binary with unsuccessful exit and return its output",
+			binPath:      "./test_bins/exit_1.sh",
+			wantStdout:   helloWorldOutput,
+			wantExitCode: 1,
+			wantErr:      true,
+		},
+		{
+			name:          "fail running nonexistent binary",
+			binPath:       "invalid.exec",
+			wantErr:       true,
+			wantNilResult: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false)
+			require.NoError(t, c.Setup())
+			result, err := c.Run(context.Background(), tt.binPath, tt.mainTestName, nil, nil)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			if tt.wantNilResult {
+				require.Nil(t, result)
+				return
+			}
+			require.Equal(t, tt.wantStdout, result.Stdout)
+			require.Equal(t, tt.wantStderr, result.Stderr)
+			require.Len(t, result.Combined, len(tt.wantStdout)+len(tt.wantStderr))
+			require.Contains(t, result.Combined, tt.wantStdout)
+			require.Contains(t, result.Combined, tt.wantStderr)
+			require.Equal(t, tt.wantExitCode, result.ExitCode)
+		})
+	}
+}
+
+func TestCoverageCollector_Exec(t *testing.T) {
+	tests := []struct {
+		name            string
+		binPath         string
+		mainTestName    string
+		args            []string
+		collectCoverage bool
+		options         []CoverageCollectorOption
+		wantStdout      string
+		wantExitCode    int
+		wantSignal      os.Signal
+		wantCoverMode   string
+		wantErr         bool
+	}{
+		{
+			name:         "succeed running binary with unsuccessful exit",
+			binPath:      "./test_bins/exit_1.sh",
+			wantStdout:   helloWorldOutput,
+			wantExitCode: 1,
+		},
+		{
+			name:         "succeed running binary killed by a signal",
+			binPath:      "./test_bins/kill_self.sh",
+			wantStdout:   helloWorldOutput,
+			wantExitCode: -1,
+			wantSignal:   syscall.SIGKILL,
+		},
+		{
+			name:            "succeed running instrumented binary with coverage",
+			binPath:         "./set_covermode",
+			mainTestName:    "TestRunMain",
+			args:            []string{"arg1", "arg2"},
+			collectCoverage: true,
+			wantStdout:      helloWorldOutput,
+			wantExitCode:    1,
+			wantCoverMode:   set,
+		},
+		{
+			name:         "fail running binary with unsuccessful exit when success is expected",
+			binPath:      "./test_bins/exit_1.sh",
+			options:      []CoverageCollectorOption{ExpectSuccess()},
+			wantStdout:   helloWorldOutput,
+			wantExitCode: 1,
+			wantErr:      true,
+		},
+		{
+			name:          "fail running instrumented binary reporting a nonzero exit code when success is expected",
+			binPath:       "./set_covermode",
+			mainTestName:  "TestRunMain",
+			options:       []CoverageCollectorOption{ExpectSuccess()},
+			wantStdout:    helloWorldOutput,
+			wantExitCode:  1,
+			wantCoverMode: set,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector(filepath.Join(t.TempDir(), "coverage.out"), tt.collectCoverage)
+			require.NoError(t, c.Setup())
+			defer func() {
+				require.NoError(t, c.TearDown())
+			}()
+			result, err := c.Exec(context.Background(), tt.binPath, tt.mainTestName, []string{"FOO=bar"}, tt.args, tt.options...)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NotNil(t, result)
+			require.Equal(t, tt.wantStdout, result.Stdout)
+			require.Equal(t, tt.wantExitCode, result.ExitCode)
+			require.Equal(t, tt.wantSignal, result.Signal)
+			require.Equal(t, tt.wantCoverMode, result.CoverMode)
+			require.Equal(t, tt.args, result.Args)
+			require.Contains(t, result.Env, "FOO=bar")
+			require.Positive(t, result.Duration)
+			if tt.collectCoverage {
+				require.FileExists(t, result.CoverProfile)
+			} else {
+				require.Empty(t, result.CoverProfile)
+			}
+		})
+	}
+}
+
+func TestCoverageCollector_RunBinaryContext(t *testing.T) {
+	tests := []struct {
+		name             string
+		binPath          string
+		collectCoverage  bool
+		wantOutput       string
+		wantCoverageFile bool
+	}{
+		{
+			name:       "fail running binary which outlives its context",
+			binPath:    "./test_bins/sleep.sh",
+			wantOutput: "Going to sleep\n",
+		},
+		{
+			name:             "keep coverage profile written before binary was stopped",
+			binPath:          "./test_bins/partial_coverage.sh",
+			collectCoverage:  true,
+			wantOutput:       "Wrote coverage profile\n",
+			wantCoverageFile: true,
+		},
+		{
+			name:            "discard empty coverage profile of binary which was stopped",
+			binPath:         "./test_bins/sleep.sh",
+			collectCoverage: true,
+			wantOutput:      "Going to sleep\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("temp_coverage.out", tt.collectCoverage)
+			require.NoError(t, c.Setup())
+			defer c.removeTempFiles()
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			output, exitCode, err := c.RunBinaryContext(ctx, tt.binPath, "", nil, nil)
+			require.Empty(t, output)
+			require.Equal(t, -1, exitCode)
+			var timeoutErr *TimeoutError
+			require.True(t, errors.As(err, &timeoutErr))
+			require.Equal(t, tt.wantOutput, timeoutErr.Output)
+			if tt.wantCoverageFile {
+				require.Len(t, c.tmpCoverageFiles, 1)
+				require.Equal(t, set, c.coverMode)
+			} else {
+				require.Empty(t, c.tmpCoverageFiles)
+			}
+		})
+	}
+}
+
+func TestCoverageCollector_RunBinary_legacyBinary(t *testing.T) {
+	mergedFilename := filepath.Join(t.TempDir(), "merged.out")
+	c := NewCoverageCollector(mergedFilename, true)
+	require.NoError(t, c.Setup())
+	// The first run is rejected for -metadata-file and rerun without it, with the same input.
+	output, exitCode, err := c.RunBinary("./legacy", "TestRunMain", nil, []string{"a", "b"}, Stdin(strings.NewReader("first")))
+	require.NoError(t, err)
+	require.Equal(t, "Args: a b\nInput: first\n", output)
+	require.Equal(t, 0, exitCode)
+	supported, known := c.supportsMetadataFile("./legacy")
+	require.False(t, supported)
+	require.True(t, known)
+	output, exitCode, err = c.RunBinary("./legacy", "TestRunMain", nil, nil, StdinString("second"))
+	require.NoError(t, err)
+	require.Equal(t, "Args: \nInput: second\n", output)
+	require.Equal(t, 0, exitCode)
+	require.NoError(t, c.TearDown())
+	merged, err := os.ReadFile(mergedFilename)
+	require.NoError(t, err)
+	require.Contains(t, string(merged), "test_bins/legacy/main.go")
+}
+
+func TestCoverageCollector_RunBinary_failedRunDiscardsCoverage(t *testing.T) {
+	c := NewCoverageCollector("temp_coverage.out", true)
+	require.NoError(t, c.Setup())
+	defer c.removeTempFiles()
+	_, exitCode, err := c.RunBinary("./test_bins/coverage_exit_1.sh", "", nil, nil)
+	require.Error(t, err)
+	require.Equal(t, 1, exitCode)
+	require.Empty(t, c.tmpCoverageFiles)
+}
+
+func TestCoverageCollector_RunBinary_osExit(t *testing.T) {
+	for _, collectCoverage := range []bool{false, true} {
+		t.Run(fmt.Sprintf("collect coverage %t", collectCoverage), func(t *testing.T) {
+			mergedFilename := filepath.Join(t.TempDir(), "merged.out")
+			c := NewCoverageCollector(mergedFilename, collectCoverage)
+			require.NoError(t, c.Setup())
+			output, exitCode, err := c.RunBinary("./os_exit", "TestRunMain", nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, "Exiting with code 3\n", output)
+			require.Equal(t, 3, exitCode)
+			require.Empty(t, c.tmpCoverageFiles)
+			if !collectCoverage || !hasReleaseTag("go1.20") {
+				return
+			}
+			require.Len(t, c.tmpCoverDirs, 1)
+			require.NoError(t, c.TearDown())
+			buf, err := os.ReadFile(mergedFilename)
+			require.NoError(t, err)
+			require.Contains(t, string(buf), "test_bins/os_exit/main.go:9.2,11.1 2 1\n")
+		})
+	}
+}
+
+func TestCoverageCollector_RunBinary_osExitRejectedCoverMode(t *testing.T) {
+	c := NewCoverageCollector(filepath.Join(t.TempDir(), "merged.out"), true, TargetCoverMode(count))
+	require.NoError(t, c.Setup())
+	_, exitCode, err := c.RunBinary("./os_exit", "TestRunMain", nil, nil)
+	require.ErrorIs(t, err, ErrCoverModeMismatch)
+	require.Equal(t, 3, exitCode)
+	require.Empty(t, c.tmpCoverageFiles)
+	require.Empty(t, c.tmpCoverDirs)
+}
+
+func TestCoverageCollector_RunBinary_parallel(t *testing.T) {
+	c := NewCoverageCollector(filepath.Join(t.TempDir(), "merged.out"), true)
+	c.MaxConcurrency = 2
+	require.NoError(t, c.Setup())
+	for i := 0; i < 8; i++ {
+		t.Run(fmt.Sprintf("run %d", i), func(t *testing.T) {
+			t.Parallel()
+			output, exitCode, err := c.RunBinary("./set_covermode", "TestRunMain", nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, helloWorldOutput, output)
+			require.Equal(t, 1, exitCode)
+		})
+	}
+	// The parallel subtests only run once this function has returned, so clean up after them.
+	t.Cleanup(func() {
+		require.Len(t, c.tmpCoverageFiles, 8)
+		c.removeTempFiles()
+	})
+}
+
+func TestCoverageCollector_RunBinary_optionsApplyToSingleRun(t *testing.T) {
+	var buffer bytes.Buffer
+	c := NewCoverageCollector("", false, PostExec(printCommandOutputToBuffer(&buffer)))
+	require.NoError(t, c.Setup())
+	_, _, err := c.RunBinary("./set_covermode", "TestRunMain", nil, nil, PreExec(printToBufferPreFunc(&buffer, preFuncHelloMsg)))
+	require.NoError(t, err)
+	_, _, err = c.RunBinary("./set_covermode", "TestRunMain", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, preFuncHelloMsg+helloWorldOutput+helloWorldOutput, buffer.String())
+}
+
 func stdinPipePreFuncCovCollectorOption() CoverageCollectorOption {
 	f := PreCmdFunc(func(cmd *exec.Cmd) error {
 		writer, _ := cmd.StdinPipe()
@@ -848,3 +1259,267 @@ func tempFileWithContent(t *testing.T, content string) *os.File {
 	require.NoError(t, err)
 	return f
 }
+
+func TestCoverageCollector_AddProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		profiles   []string
+		wantMerged string
+		wantErr    bool
+		errMessage string
+	}{
+		{
+			name:       "succeed merging added profiles",
+			profiles:   []string{"mode: count\na.go:1.1,2.2 1 1\n", "mode: count\na.go:1.1,2.2 1 2\nb.go:1.1,2.2 1 0\n"},
+			wantMerged: "mode: count\na.go:1.1,2.2 1 3\nb.go:1.1,2.2 1 0\n",
+		},
+		{
+			name:       "succeed merging added profiles with different modes",
+			profiles:   []string{"mode: count\na.go:1.1,2.2 1 1\n", "mode: set\na.go:1.1,2.2 1 0\nb.go:1.1,2.2 1 0\n"},
+			wantMerged: "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 0\n",
+		},
+		{
+			name:       "fail adding corrupted profile",
+			profiles:   []string{"a.go:1.1,2.2 1 1\n"},
+			wantErr:    true,
+			errMessage: "error reading coverage profile \"<filename>\": error parsing coverage profile: missing coverage mode from coverage profile. Maybe the file got corrupted while writing?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mergedFilename := filepath.Join(dir, "merged.out")
+			c := NewCoverageCollector(mergedFilename, true)
+			require.NoError(t, c.Setup())
+			var err error
+			for i, content := range tt.profiles {
+				filename := filepath.Join(dir, fmt.Sprintf("profile_%d.out", i))
+				require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+				if err = c.AddProfile(filename); err != nil {
+					if tt.wantErr {
+						require.EqualError(t, err, strings.ReplaceAll(tt.errMessage, "<filename>", filename))
+						return
+					}
+					break
+				}
+			}
+			require.NoError(t, err)
+			require.NoError(t, c.TearDown())
+			buf, err := os.ReadFile(mergedFilename)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMerged, string(buf))
+		})
+	}
+}
+
+func TestCoverageCollector_IncludeProfiles(t *testing.T) {
+	dir := t.TempDir()
+	unitFilename := filepath.Join(dir, "unit.out")
+	mergedFilename := filepath.Join(dir, "merged.out")
+	c := NewCoverageCollector(mergedFilename, true, IncludeProfiles(unitFilename))
+	require.NoError(t, c.Setup())
+	_, _, err := c.RunBinary("./set_covermode", "TestRunMain", nil, nil)
+	require.NoError(t, err)
+	// The unit test profile is only written once the runs are done, and counts "other.go".
+	require.NoError(t, os.WriteFile(unitFilename, []byte("mode: count\nexample.com/unit/other.go:1.1,2.2 1 3\n"), 0600))
+	require.NoError(t, c.TearDown())
+	buf, err := os.ReadFile(mergedFilename)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(buf), "mode: set\nexample.com/unit/other.go:1.1,2.2 1 1\n"))
+	require.Contains(t, string(buf), "github.com/confluentinc/bincover/test_bins/set_covermode.go:10.2,12.1 2 1\n")
+}
+
+func TestCoverageCollector_TargetCoverMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       []CoverageCollectorOption
+		binPaths      []string
+		wantSetupErr  string
+		wantRunErr    string
+		wantCoverMode string
+		wantMerged    string
+	}{
+		{
+			name:          "succeed merging count and set runs into set",
+			binPaths:      []string{"./test_bins/count_covermode.sh", "./set_covermode"},
+			wantCoverMode: set,
+		},
+		{
+			name:          "succeed converting count run to target set mode",
+			options:       []CoverageCollectorOption{TargetCoverMode(set)},
+			binPaths:      []string{"./test_bins/count_covermode.sh"},
+			wantCoverMode: count,
+			wantMerged:    "mode: set\na.go:1.1,2.2 1 1\n",
+		},
+		{
+			name:          "succeed converting count run to target atomic mode",
+			options:       []CoverageCollectorOption{TargetCoverMode(atomic)},
+			binPaths:      []string{"./test_bins/count_covermode.sh"},
+			wantCoverMode: count,
+			wantMerged:    "mode: atomic\na.go:1.1,2.2 1 3\n",
+		},
+		{
+			name:          "fail converting set run to target count mode",
+			options:       []CoverageCollectorOption{TargetCoverMode(count)},
+			binPaths:      []string{"./test_bins/count_covermode.sh", "./set_covermode"},
+			wantRunErr:    "cannot convert coverage mode \"set\" to the target mode \"count\"",
+			wantCoverMode: count,
+			wantMerged:    "mode: count\na.go:1.1,2.2 1 3\n",
+		},
+		{
+			name:         "fail setting up with unknown target mode",
+			options:      []CoverageCollectorOption{TargetCoverMode("evil")},
+			wantSetupErr: "unexpected target coverage mode \"evil\". Coverage mode must be set, count, or atomic",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergedFilename := filepath.Join(t.TempDir(), "merged.out")
+			c := NewCoverageCollector(mergedFilename, true, tt.options...)
+			err := c.Setup()
+			if tt.wantSetupErr != "" {
+				require.EqualError(t, err, tt.wantSetupErr)
+				return
+			}
+			require.NoError(t, err)
+			for _, binPath := range tt.binPaths {
+				_, _, err = c.RunBinary(binPath, "TestRunMain", nil, nil)
+			}
+			if tt.wantRunErr != "" {
+				require.ErrorIs(t, err, ErrCoverModeMismatch)
+				require.Contains(t, err.Error(), tt.wantRunErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantCoverMode, c.coverMode)
+			require.NoError(t, c.TearDown())
+			if tt.wantMerged != "" {
+				buf, err := os.ReadFile(mergedFilename)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantMerged, string(buf))
+			}
+		})
+	}
+}
+
+func TestCoverageCollector_RunBinary_stdin(t *testing.T) {
+	stdinFilename := filepath.Join(t.TempDir(), "stdin.txt")
+	require.NoError(t, os.WriteFile(stdinFilename, []byte("Hello from file\n"), 0600))
+	// Larger than a pipe buffer, so that writing it all before reading the output would deadlock.
+	largeInput := strings.Repeat(strings.Repeat("x", 99)+"\n", 20000)
+	tests := []struct {
+		name       string
+		option     CoverageCollectorOption
+		wantOutput string
+		wantErr    bool
+		errMessage string
+	}{
+		{
+			name:       "succeed reading stdin from reader",
+			option:     Stdin(bytes.NewBufferString("Hello from reader\n")),
+			wantOutput: "Hello from reader\n",
+		},
+		{
+			name:       "succeed reading stdin from string",
+			option:     StdinString("Hello\nworld\n"),
+			wantOutput: "Hello\nworld\n",
+		},
+		{
+			name:       "succeed reading stdin from file",
+			option:     StdinFile(stdinFilename),
+			wantOutput: "Hello from file\n",
+		},
+		{
+			name:       "succeed reading large stdin",
+			option:     StdinString(largeInput),
+			wantOutput: largeInput,
+		},
+		{
+			name:       "fail reading stdin from nonexistent file",
+			option:     StdinFile("nonexistent.txt"),
+			wantErr:    true,
+			errMessage: "error opening stdin file: open nonexistent.txt: no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false)
+			require.NoError(t, c.Setup())
+			output, exitCode, err := c.RunBinary("./test_bins/read_stdin.sh", "TestRunMain", nil, nil, tt.option)
+			if tt.wantErr {
+				require.EqualError(t, err, tt.errMessage)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOutput, output)
+			require.Equal(t, 1, exitCode)
+		})
+	}
+}
+
+func TestCoverageCollector_RunBinary_stdinFileKeptOpen(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	_, err = w.WriteString("Hello from pipe\n")
+	require.NoError(t, err)
+	c := NewCoverageCollector("", false)
+	require.NoError(t, c.Setup())
+	// The binary exits without reading to EOF, which the still open pipe would never reach.
+	start := time.Now()
+	output, exitCode, err := c.RunBinary("./test_bins/exit_1.sh", "", nil, nil, Stdin(r))
+	require.Error(t, err)
+	require.Equal(t, "", output)
+	require.Equal(t, 1, exitCode)
+	require.Less(t, time.Since(start), 3*time.Second)
+	// The pipe belongs to the caller, so it is still open.
+	_, err = w.WriteString("still open\n")
+	require.NoError(t, err)
+}
+
+func TestCoverageCollector_RunBinary_sandbox(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixtureDir string
+		wantOutput string
+		wantFiles  map[string]string
+		wantErr    bool
+		errMessage string
+	}{
+		{
+			name:       "succeed running binary in empty sandbox",
+			wantOutput: "Running in HOME\n",
+			wantFiles:  map[string]string{".config/ourcli/config": "written by binary\n"},
+		},
+		{
+			name:       "succeed running binary in sandbox seeded from fixture",
+			fixtureDir: "./test_bins/sandbox_fixture",
+			wantOutput: "Running in HOME\nprofile=test\n",
+			wantFiles: map[string]string{
+				".config/ourcli/config": "written by binary\n",
+				"data/settings.txt":     "profile=test\n",
+			},
+		},
+		{
+			name:       "fail seeding sandbox from nonexistent fixture",
+			fixtureDir: "./test_bins/nonexistent",
+			wantErr:    true,
+			errMessage: "error copying fixture into sandbox: lstat ./test_bins/nonexistent: no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false, Sandbox(tt.fixtureDir))
+			require.NoError(t, c.Setup())
+			result, err := c.Run(context.Background(), "./test_bins/sandbox.sh", "", nil, nil)
+			if tt.wantErr {
+				require.EqualError(t, err, tt.errMessage)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOutput, result.Stdout)
+			require.Equal(t, tt.wantFiles, result.SandboxFiles)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package bincover
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultTmpSandboxDirPrefix = "bincover_sandbox"
+
+// Sandbox runs the binary in a fresh temporary directory, created for every run and removed afterwards.
+// The directory is the binary's working directory and its HOME, and the XDG base directory variables
+// (XDG_CONFIG_HOME, XDG_DATA_HOME, XDG_STATE_HOME and XDG_CACHE_HOME) point inside it, so that files the binary
+// writes to the user's home directory stay out of the real one and don't leak between runs.
+// If fixtureDir is not empty, the directory is seeded with a copy of fixtureDir.
+// The files in the directory after the binary exits are returned in RunResult.SandboxFiles.
+func Sandbox(fixtureDir string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.sandbox = true
+		c.sandboxFixtureDir = fixtureDir
+	}
+}
+
+// Dir runs the binary in dir instead of the test process's working directory. It takes precedence over the
+// working directory set by Sandbox.
+func Dir(dir string) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.dir = dir
+	}
+}
+
+// sandbox is the temporary directory a single run is confined to. A nil *sandbox leaves the run unconfined.
+type sandbox struct {
+	dir string
+}
+
+// setupDir makes cmd run in the working directory cfg asks for, if any.
+func (cfg runConfig) setupDir(cmd *exec.Cmd, sandbox *sandbox) error {
+	if err := sandbox.setup(cmd); err != nil {
+		return err
+	}
+	if cfg.dir != "" {
+		return setCmdDir(cmd, cfg.dir)
+	}
+	return nil
+}
+
+// newSandbox creates the sandbox for a run with cfg, if cfg asks for one.
+func (cfg runConfig) newSandbox() (*sandbox, error) {
+	if !cfg.sandbox {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", defaultTmpSandboxDirPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating sandbox directory")
+	}
+	// Binaries that resolve paths, like os.Getwd does, should see the same path as the one in HOME.
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	s := &sandbox{dir: dir}
+	if cfg.sandboxFixtureDir != "" {
+		if err := copyTree(cfg.sandboxFixtureDir, dir); err != nil {
+			s.remove()
+			return nil, errors.Wrap(err, "error copying fixture into sandbox")
+		}
+	}
+	return s, nil
+}
+
+// env returns the variables pointing HOME and the XDG base directories into the sandbox.
+func (s *sandbox) env() []string {
+	if s == nil {
+		return nil
+	}
+	return []string{
+		"HOME=" + s.dir,
+		"XDG_CONFIG_HOME=" + filepath.Join(s.dir, ".config"),
+		"XDG_DATA_HOME=" + filepath.Join(s.dir, ".local", "share"),
+		"XDG_STATE_HOME=" + filepath.Join(s.dir, ".local", "state"),
+		"XDG_CACHE_HOME=" + filepath.Join(s.dir, ".cache"),
+	}
+}
+
+// setup makes cmd run in the sandbox.
+func (s *sandbox) setup(cmd *exec.Cmd) error {
+	if s == nil {
+		return nil
+	}
+	return setCmdDir(cmd, s.dir)
+}
+
+// setCmdDir makes cmd run in dir.
+func setCmdDir(cmd *exec.Cmd, dir string) error {
+	// A relative binary path would otherwise be resolved relative to dir.
+	if !filepath.IsAbs(cmd.Path) && strings.ContainsRune(cmd.Path, filepath.Separator) {
+		path, err := filepath.Abs(cmd.Path)
+		if err != nil {
+			return err
+		}
+		cmd.Path = path
+	}
+	cmd.Dir = dir
+	return nil
+}
+
+// files returns the contents of every regular file in the sandbox, keyed by slash-separated relative path.
+func (s *sandbox) files() (map[string]string, error) {
+	if s == nil {
+		return nil, nil
+	}
+	files := make(map[string]string)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(buf)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading sandbox directory")
+	}
+	return files, nil
+}
+
+func (s *sandbox) remove() {
+	if s == nil {
+		return
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		log.Printf("error removing sandbox directory: %s\n", err)
+	}
+}
+
+// copyTree copies the files, directories and symlinks under src into dst, preserving their permissions.
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
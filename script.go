@@ -0,0 +1,309 @@
+package bincover
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// ScriptParams configures RunScripts.
+type ScriptParams struct {
+	// Dir holds the scripts to run: every file ending in ".txtar" is a script.
+	Dir string
+	// Binaries maps the names scripts pass to exec-cover to the paths of instrumented binaries.
+	Binaries map[string]string
+	// MainTestName is the name of the test calling RunTest in the binaries. Defaults to DefaultMainTestName.
+	MainTestName string
+	// Options apply to every run of a binary.
+	Options []CoverageCollectorOption
+}
+
+// RunScripts runs every script in params.Dir as a subtest of t, named after the script's file.
+//
+// A script is a txtar archive (https://pkg.go.dev/golang.org/x/tools/txtar). Its files are written to a fresh
+// working directory, which binaries run in and which is available to the script as $WORK. Its comment holds the
+// commands, one per line; blank lines and lines starting with "#" are ignored. Arguments are separated by spaces,
+// may be quoted with single quotes, and $VAR or ${VAR} outside of quotes expands to a variable set with env.
+// Prefixing a command with "!" negates it. The commands are:
+//
+//	exec-cover name [args...]  runs binary name with RunBinary; fails unless it exits with code 0 (or nonzero with !)
+//	stdout regex               fails unless the stdout of the last exec-cover matches regex
+//	stderr regex               fails unless the stderr of the last exec-cover matches regex
+//	cmp file1 file2            fails unless file1 and file2 have the same content; stdout and stderr
+//	                           refer to the output of the last exec-cover
+//	env KEY=VALUE...           sets variables for the commands that follow
+//
+// Coverage of every run is collected by c, like that of any other run of RunBinary, and attributed to the
+// script's subtest.
+func (c *CoverageCollector) RunScripts(t *testing.T, params ScriptParams) {
+	scripts, err := filepath.Glob(filepath.Join(params.Dir, "*.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatalf("no scripts found in %s", params.Dir)
+	}
+	for _, script := range scripts {
+		script := script
+		name := strings.TrimSuffix(filepath.Base(script), ".txtar")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := params
+			params.Options = append([]CoverageCollectorOption{ForTest(t)}, params.Options...)
+			if err := c.runScript(params, script, data, t.TempDir(), t.Logf); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// scriptState is the state of a script while it runs.
+type scriptState struct {
+	c       *CoverageCollector
+	params  ScriptParams
+	workDir string
+	env     []string
+	stdout  string
+	stderr  string
+	// ran is set once an exec-cover command has run.
+	ran bool
+}
+
+// runScript runs the script named name, with contents data, in workDir. logf logs each command as it runs.
+func (c *CoverageCollector) runScript(params ScriptParams, name string, data []byte, workDir string, logf func(format string, args ...interface{})) error {
+	archive := parseTxtar(data)
+	for _, file := range archive.files {
+		path, err := workPath(workDir, file.name)
+		if err != nil {
+			return errors.Wrapf(err, "%s", name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, file.data, 0644); err != nil {
+			return errors.Wrap(err, "error writing script file")
+		}
+	}
+	s := &scriptState{c: c, params: params, workDir: workDir, env: []string{"WORK=" + workDir}}
+	for i, line := range strings.Split(archive.comment, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		logf("> %s", line)
+		if err := s.runLine(line); err != nil {
+			return errors.Errorf("%s:%d: %s: %s", name, i+1, line, err)
+		}
+	}
+	return nil
+}
+
+func (s *scriptState) runLine(line string) error {
+	negate := false
+	if strings.HasPrefix(line, "!") {
+		negate = true
+		line = strings.TrimSpace(line[1:])
+	}
+	args, err := s.splitLine(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+	switch args[0] {
+	case "exec-cover":
+		return s.execCover(negate, args[1:])
+	case "stdout":
+		return s.match(negate, "stdout", s.stdout, args[1:])
+	case "stderr":
+		return s.match(negate, "stderr", s.stderr, args[1:])
+	case "cmp":
+		return s.cmp(negate, args[1:])
+	case "env":
+		if negate {
+			return errors.New("env cannot be negated")
+		}
+		return s.setEnv(args[1:])
+	default:
+		return errors.Errorf("unknown command \"%s\"", args[0])
+	}
+}
+
+func (s *scriptState) execCover(negate bool, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: exec-cover name [args...]")
+	}
+	binPath, ok := s.params.Binaries[args[0]]
+	if !ok {
+		return errors.Errorf("unknown binary \"%s\"", args[0])
+	}
+	options := append(append([]CoverageCollectorOption(nil), s.params.Options...), Dir(s.workDir))
+	result, err := s.c.Run(context.Background(), binPath, s.params.MainTestName, s.env, args[1:], options...)
+	if result == nil {
+		return err
+	}
+	s.stdout, s.stderr, s.ran = result.Stdout, result.Stderr, true
+	failed := err != nil || result.ExitCode != 0
+	switch {
+	case failed && !negate:
+		if err != nil {
+			return err
+		}
+		return errors.Errorf("unexpected exit code %d\nstdout:\n%s\nstderr:\n%s", result.ExitCode, result.Stdout, result.Stderr)
+	case !failed && negate:
+		return errors.Errorf("unexpected success\nstdout:\n%s\nstderr:\n%s", result.Stdout, result.Stderr)
+	}
+	return nil
+}
+
+func (s *scriptState) match(negate bool, stream string, output string, args []string) error {
+	if len(args) != 1 {
+		return errors.Errorf("usage: %s regex", stream)
+	}
+	if !s.ran {
+		return errors.New("no exec-cover command ran before")
+	}
+	re, err := regexp.Compile("(?m)" + args[0])
+	if err != nil {
+		return err
+	}
+	matched := re.MatchString(output)
+	switch {
+	case !matched && !negate:
+		return errors.Errorf("no match for %q in %s:\n%s", args[0], stream, output)
+	case matched && negate:
+		return errors.Errorf("unexpected match for %q in %s:\n%s", args[0], stream, output)
+	}
+	return nil
+}
+
+func (s *scriptState) cmp(negate bool, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: cmp file1 file2")
+	}
+	got, err := s.readFile(args[0])
+	if err != nil {
+		return err
+	}
+	want, err := s.readFile(args[1])
+	if err != nil {
+		return err
+	}
+	switch {
+	case got != want && !negate:
+		return errors.Errorf("%s and %s differ\n%s", args[0], args[1], unifiedDiff(args[1], args[0], want, got))
+	case got == want && negate:
+		return errors.Errorf("%s and %s do not differ", args[0], args[1])
+	}
+	return nil
+}
+
+// readFile returns the contents of the file at name, relative to the working directory,
+// or the output of the last exec-cover if name is "stdout" or "stderr".
+func (s *scriptState) readFile(name string) (string, error) {
+	switch name {
+	case "stdout", "stderr":
+		if !s.ran {
+			return "", errors.New("no exec-cover command ran before")
+		}
+		if name == "stdout" {
+			return s.stdout, nil
+		}
+		return s.stderr, nil
+	}
+	path, err := workPath(s.workDir, name)
+	if err != nil {
+		return "", err
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// workPath returns the path in workDir of the slash-separated file name. Absolute names, and names leading out of
+// workDir through "..", are an error, so that a script can't write or read files elsewhere.
+func workPath(workDir string, name string) (string, error) {
+	if filepath.IsAbs(filepath.FromSlash(name)) {
+		return "", errors.Errorf("file %q is outside the working directory", name)
+	}
+	path := filepath.Join(workDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(workDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("file %q is outside the working directory", name)
+	}
+	return path, nil
+}
+
+func (s *scriptState) setEnv(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: env KEY=VALUE...")
+	}
+	for _, kv := range args {
+		if !strings.Contains(kv, "=") {
+			return errors.Errorf("environment variable \"%s\" must have the form KEY=VALUE", kv)
+		}
+	}
+	s.env = resolveEnv(s.env, args)
+	return nil
+}
+
+func (s *scriptState) lookupEnv(key string) string {
+	for i := len(s.env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(s.env[i], key+"=") {
+			return s.env[i][len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// splitLine splits line into arguments at unquoted spaces, expanding variables outside of single quotes.
+func (s *scriptState) splitLine(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	unquoted := ""
+	flush := func() {
+		arg.WriteString(os.Expand(unquoted, s.lookupEnv))
+		unquoted = ""
+	}
+	for _, r := range line {
+		switch {
+		case r == '\'':
+			if !quoted {
+				flush()
+			}
+			quoted, inArg = !quoted, true
+		case quoted:
+			arg.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if inArg {
+				flush()
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			unquoted += string(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quoted argument")
+	}
+	if inArg {
+		flush()
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
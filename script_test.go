@@ -0,0 +1,97 @@
+package bincover
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTxtar(t *testing.T) {
+	archive := parseTxtar([]byte("comment\n-- a.txt --\nA\n-- dir/b.txt --\nB"))
+	require.Equal(t, "comment\n", archive.comment)
+	require.Equal(t, []txtarFile{{name: "a.txt", data: []byte("A\n")}, {name: "dir/b.txt", data: []byte("B\n")}}, archive.files)
+}
+
+func TestCoverageCollector_RunScripts(t *testing.T) {
+	c := NewCoverageCollector("", false)
+	require.NoError(t, c.Setup())
+	binPath, err := filepath.Abs("./test_bins/script_bin.sh")
+	require.NoError(t, err)
+	c.RunScripts(t, ScriptParams{Dir: "./test_bins/scripts", Binaries: map[string]string{"script_bin": binPath}})
+}
+
+func TestCoverageCollector_runScript(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		errMessage string
+	}{
+		{
+			name:       "fail running unknown command",
+			script:     "frobnicate\n",
+			errMessage: "test.txtar:1: frobnicate: unknown command \"frobnicate\"",
+		},
+		{
+			name:       "fail running unknown binary",
+			script:     "# comment\nexec-cover other\n",
+			errMessage: "test.txtar:2: exec-cover other: unknown binary \"other\"",
+		},
+		{
+			name:       "fail running binary exiting with nonzero code",
+			script:     "env EXIT_CODE=2\nexec-cover script_bin\n",
+			errMessage: "test.txtar:2: exec-cover script_bin: unexpected exit code 2\nstdout:\nargs: \n\nstderr:\n",
+		},
+		{
+			name:       "fail running binary that unexpectedly succeeds",
+			script:     "! exec-cover script_bin\n",
+			errMessage: "test.txtar:1: ! exec-cover script_bin: unexpected success\nstdout:\nargs: \n\nstderr:\n",
+		},
+		{
+			name:       "fail matching stdout",
+			script:     "exec-cover script_bin a\nstdout '^args: b$'\n",
+			errMessage: "test.txtar:2: stdout '^args: b$': no match for \"^args: b$\" in stdout:\nargs: a\n",
+		},
+		{
+			name:       "fail matching stderr before running a binary",
+			script:     "! stderr .\n",
+			errMessage: "test.txtar:1: ! stderr .: no exec-cover command ran before",
+		},
+		{
+			name:       "fail comparing different files",
+			script:     "cmp a.txt b.txt\n-- a.txt --\nA\n-- b.txt --\nB\n",
+			errMessage: "test.txtar:1: cmp a.txt b.txt: a.txt and b.txt differ\n--- b.txt\n+++ a.txt\n@@ -1 +1 @@\n-B\n+A\n",
+		},
+		{
+			name:       "fail writing file outside the working directory",
+			script:     "exec-cover script_bin\n-- ../escaped.txt --\nA\n",
+			errMessage: "test.txtar: file \"../escaped.txt\" is outside the working directory",
+		},
+		{
+			name:       "fail writing file with absolute name",
+			script:     "exec-cover script_bin\n-- /tmp/escaped.txt --\nA\n",
+			errMessage: "test.txtar: file \"/tmp/escaped.txt\" is outside the working directory",
+		},
+		{
+			name:       "fail comparing file outside the working directory",
+			script:     "cmp a.txt dir/../../b.txt\n-- a.txt --\nA\n",
+			errMessage: "test.txtar:1: cmp a.txt dir/../../b.txt: file \"dir/../../b.txt\" is outside the working directory",
+		},
+		{
+			name:       "fail splitting unterminated quote",
+			script:     "exec-cover script_bin 'a\n",
+			errMessage: "test.txtar:1: exec-cover script_bin 'a: unterminated quoted argument",
+		},
+	}
+	binPath, err := filepath.Abs("./test_bins/script_bin.sh")
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false)
+			require.NoError(t, c.Setup())
+			params := ScriptParams{Binaries: map[string]string{"script_bin": binPath}}
+			err := c.runScript(params, "test.txtar", []byte(tt.script), t.TempDir(), t.Logf)
+			require.EqualError(t, err, tt.errMessage)
+		})
+	}
+}
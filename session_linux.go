@@ -0,0 +1,236 @@
+package bincover
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultSessionTimeout = 10 * time.Second
+
+// Session is an instrumented binary running under a pseudo-terminal, so that code paths which require stdin to be
+// a TTY, such as password prompts and confirmations, can be driven and measured.
+// The binary's stdout and stderr both go to the terminal, so they are not told apart, and the terminal turns every
+// "\n" the binary writes into "\r\n".
+type Session struct {
+	// Timeout is how long ExpectString and ExpectEOF wait for the binary, and how long Close waits for it to exit
+	// before stopping it. Defaults to 10 seconds.
+	Timeout time.Duration
+	c       *CoverageCollector
+	cfg     runConfig
+	run     *testRun
+	ptm     *os.File
+	// waitDone receives the result of waiting for the binary to exit.
+	waitDone chan error
+	// started is when the binary was started, and exited when it exited. exited is set before waitDone is sent to.
+	started time.Time
+	exited  time.Time
+	// outputDone is closed once all of the binary's output has been read.
+	outputDone chan struct{}
+	mu         sync.Mutex
+	output     bytes.Buffer
+	// matched is the length of the output already consumed by ExpectString.
+	matched int
+	// eof is set once all of the binary's output has been read.
+	eof bool
+	// changed is closed, and replaced, whenever output is added or eof is set.
+	changed chan struct{}
+	closed  bool
+}
+
+// StartSession starts the instrumented binary at binPath under a new pseudo-terminal, like RunBinary does
+// with pipes. Interact with the binary through the returned Session, and call Close to wait for the binary to exit
+// and collect its coverage. StartSession is only available on Linux, and doesn't support UseGoCoverDir.
+func (c *CoverageCollector) StartSession(binPath string, mainTestName string, env []string, args []string, options ...CoverageCollectorOption) (*Session, error) {
+	if !c.setupFinished {
+		return nil, errorOf(ErrNotSetUp, "StartSession called before Setup")
+	}
+	if c.UseGoCoverDir {
+		return nil, errors.New("sessions don't support UseGoCoverDir")
+	}
+	cfg, err := c.runConfigWith(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.acquire(context.Background()); err != nil {
+		return nil, err
+	}
+	s, err := c.startSession(cfg, binPath, mainTestName, env, args)
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *CoverageCollector) startSession(cfg runConfig, binPath string, mainTestName string, env []string, args []string) (*Session, error) {
+	useMetadataFile, _ := c.supportsMetadataFile(binPath)
+	run, err := c.newTestRun(cfg, binPath, mainTestName, env, args, useMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	ptm, pts, err := openPty()
+	if err != nil {
+		run.cleanup()
+		return nil, err
+	}
+	// The binary's terminal must not be closed by the time the binary exits.
+	defer pts.Close()
+	run.cmd.Stdin, run.cmd.Stdout, run.cmd.Stderr = pts, pts, pts
+	run.cmd.SysProcAttr = ptySysProcAttr()
+	for _, cmdFunc := range cfg.preCmdFuncs {
+		if err := cmdFunc(run.cmd); err != nil {
+			_ = ptm.Close()
+			run.cleanup()
+			return nil, err
+		}
+	}
+	started := time.Now()
+	if err := run.cmd.Start(); err != nil {
+		_ = ptm.Close()
+		run.cleanup()
+		return nil, errors.Wrapf(err, "unexpected error running command \"%s\"", binPath)
+	}
+	s := &Session{
+		c:          c,
+		cfg:        cfg,
+		run:        run,
+		ptm:        ptm,
+		waitDone:   make(chan error, 1),
+		outputDone: make(chan struct{}),
+		changed:    make(chan struct{}),
+		started:    started,
+	}
+	go func() {
+		err := run.cmd.Wait()
+		s.exited = time.Now()
+		s.waitDone <- err
+	}()
+	go s.readOutput()
+	return s, nil
+}
+
+func (s *Session) readOutput() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.ptm.Read(buf)
+		s.mu.Lock()
+		s.output.Write(buf[:n])
+		if err != nil {
+			// Linux reports EIO once the binary, and every process it started, closed the terminal.
+			s.eof = true
+			close(s.outputDone)
+		}
+		close(s.changed)
+		s.changed = make(chan struct{})
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Session) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return defaultSessionTimeout
+	}
+	return s.Timeout
+}
+
+// Output returns everything the binary has written to the terminal so far.
+func (s *Session) Output() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.output.String()
+}
+
+// ExpectString waits until the binary writes str, and consumes the output up to and including it,
+// so that the next ExpectString only matches output written afterwards.
+// It fails if str isn't written within Timeout, or if the binary exits without writing it.
+func (s *Session) ExpectString(str string) error {
+	timer := time.NewTimer(s.timeout())
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		unmatched := s.output.String()[s.matched:]
+		if i := strings.Index(unmatched, str); i != -1 {
+			s.matched += i + len(str)
+			s.mu.Unlock()
+			return nil
+		}
+		eof, changed := s.eof, s.changed
+		s.mu.Unlock()
+		if eof {
+			return errors.Errorf("binary exited before writing %q\nOutput:\n%s", str, unmatched)
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return errors.Errorf("timed out after %s waiting for %q\nOutput:\n%s", s.timeout(), str, unmatched)
+		}
+	}
+}
+
+// ExpectEOF waits until the binary has exited and all of its output has been read.
+func (s *Session) ExpectEOF() error {
+	select {
+	case <-s.outputDone:
+		return nil
+	case <-time.After(s.timeout()):
+		return errors.Errorf("timed out after %s waiting for the binary to exit\nOutput:\n%s", s.timeout(), s.Output())
+	}
+}
+
+// Send writes str to the binary's terminal, as if it was typed.
+func (s *Session) Send(str string) error {
+	if _, err := s.ptm.WriteString(str); err != nil {
+		return errors.Wrap(err, "error writing to terminal")
+	}
+	return nil
+}
+
+// SendLine writes line to the binary's terminal, followed by a newline.
+func (s *Session) SendLine(line string) error {
+	return s.Send(line + "\n")
+}
+
+// Close waits up to Timeout for the binary to exit, and collects its coverage like RunBinary does.
+// If the binary is still running, it is sent SIGTERM, followed by SIGKILL if it has not exited after the
+// collector's TerminationGracePeriod, and a *TimeoutError is returned. The result's Stdout and Combined hold
+// everything the binary wrote to the terminal.
+func (s *Session) Close() (*RunResult, error) {
+	if s.closed {
+		return nil, errors.New("session already closed")
+	}
+	s.closed = true
+	defer s.c.release()
+	defer s.run.cleanup()
+	defer s.ptm.Close()
+	var runErr error
+	var duration time.Duration
+	select {
+	case runErr = <-s.waitDone:
+		duration = s.exited.Sub(s.started)
+	case <-time.After(s.timeout()):
+		terminate(s.run.cmd, s.waitDone, s.c.TerminationGracePeriod)
+		runErr = &TimeoutError{BinPath: s.run.binPath, Output: s.Output(), Err: context.DeadlineExceeded}
+	}
+	// Output the binary wrote just before exiting may still be buffered in the terminal.
+	select {
+	case <-s.outputDone:
+	case <-time.After(gracePeriodOrDefault(s.c.TerminationGracePeriod)):
+	}
+	output := s.Output()
+	captured := &capturedOutput{stdout: output, chunks: []outputChunk{{size: len(output)}}, duration: duration}
+	// A session can't be rerun like RunBinary does, but the next session of the binary goes without the flag.
+	if err := s.c.checkMetadataFileSupport(s.run, captured, runErr); err != nil {
+		err = errors.Wrap(err, "start the session again to run the binary without it")
+		return nil, &RunError{BinPath: s.run.binPath, Output: output, Err: err}
+	}
+	return s.c.finishTestRun(s.cfg, s.run, captured, runErr)
+}
@@ -0,0 +1,110 @@
+package bincover
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func buildPromptBinary(t *testing.T) string {
+	binPath, err := Build(BuildOptions{Package: "./test_bins/prompt", Tags: []string{"testrunmain"}, CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	return binPath
+}
+
+func TestCoverageCollector_StartSession(t *testing.T) {
+	binPath := buildPromptBinary(t)
+	mergedFilename := filepath.Join(t.TempDir(), "merged.out")
+	c := NewCoverageCollector(mergedFilename, true)
+	require.NoError(t, c.Setup())
+
+	s, err := c.StartSession(binPath, "TestRunMain", nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.ExpectString("Name: "))
+	require.NoError(t, s.SendLine("gopher"))
+	require.NoError(t, s.ExpectString("Hello, gopher"))
+	require.NoError(t, s.ExpectEOF())
+	result, err := s.Close()
+	require.NoError(t, err)
+	require.Equal(t, "Name: gopher\r\nHello, gopher\r\n", result.Combined)
+	require.Zero(t, result.ExitCode)
+	_, err = s.Close()
+	require.EqualError(t, err, "session already closed")
+
+	// Without a terminal, the prompt is never shown.
+	output, exitCode, err := c.RunBinary(binPath, "TestRunMain", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "stdin is not a terminal\n", output)
+	require.Equal(t, 1, exitCode)
+
+	require.NoError(t, c.TearDown())
+	buf, err := os.ReadFile(mergedFilename)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "test_bins/prompt/main.go:22.2,24.16 3 1\n")
+}
+
+func TestSession_ExpectString(t *testing.T) {
+	binPath := buildPromptBinary(t)
+	tests := []struct {
+		name       string
+		expect     []string
+		sendLine   bool
+		wantErrMsg string
+	}{
+		{
+			name:       "fail expecting output that is never written",
+			expect:     []string{"Password: "},
+			wantErrMsg: "timed out after 200ms waiting for \"Password: \"\nOutput:\nName: ",
+		},
+		{
+			name:       "fail expecting output after the binary exited",
+			expect:     []string{"Name: ", "Goodbye"},
+			sendLine:   true,
+			wantErrMsg: "binary exited before writing \"Goodbye\"\nOutput:\nHello, gopher\r\n",
+		},
+		{
+			name:       "fail expecting output that was already consumed",
+			expect:     []string{"Name: ", "Name: "},
+			wantErrMsg: "timed out after 200ms waiting for \"Name: \"\nOutput:\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", false)
+			require.NoError(t, c.Setup())
+			s, err := c.StartSession(binPath, "TestRunMain", nil, nil)
+			require.NoError(t, err)
+			s.Timeout = 200 * time.Millisecond
+			if tt.sendLine {
+				require.NoError(t, s.SendLine("gopher"))
+			}
+			for _, str := range tt.expect[:len(tt.expect)-1] {
+				require.NoError(t, s.ExpectString(str))
+			}
+			require.EqualError(t, s.ExpectString(tt.expect[len(tt.expect)-1]), tt.wantErrMsg)
+			_ = s.SendLine("gopher")
+			_, err = s.Close()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSession_Close_timeout(t *testing.T) {
+	binPath := buildPromptBinary(t)
+	c := NewCoverageCollector(filepath.Join(t.TempDir(), "merged.out"), true)
+	c.TerminationGracePeriod = 100 * time.Millisecond
+	require.NoError(t, c.Setup())
+	s, err := c.StartSession(binPath, "TestRunMain", nil, nil)
+	require.NoError(t, err)
+	s.Timeout = 200 * time.Millisecond
+	require.NoError(t, s.ExpectString("Name: "))
+	_, err = s.Close()
+	var timeoutErr *TimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	require.Equal(t, "Name: ", timeoutErr.Output)
+	require.NoError(t, c.TearDown())
+}
@@ -0,0 +1,120 @@
+package bincover
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sourceResolver maps the file names in a coverage profile, which are import paths followed by a file name,
+// to files on disk. Files of the main module are resolved through its go.mod, and any others through "go list".
+type sourceResolver struct {
+	// modulePath and moduleDir are the path and root directory of the main module, if there is one.
+	modulePath string
+	moduleDir  string
+	// packageDirs caches the directories of packages outside the main module, keyed by import path.
+	packageDirs map[string]string
+}
+
+// newSourceResolver returns a resolver for the main module containing dir, found by looking for a go.mod file in dir
+// and its parents. Without a go.mod, only packages listed by "go list" are resolved.
+func newSourceResolver(dir string) (*sourceResolver, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &sourceResolver{packageDirs: make(map[string]string)}
+	for {
+		modulePath, err := readModulePath(filepath.Join(dir, "go.mod"))
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return nil, err
+		}
+		if err == nil {
+			r.modulePath, r.moduleDir = modulePath, dir
+			return r, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return r, nil
+		}
+		dir = parent
+	}
+}
+
+// readModulePath returns the module path declared by the go.mod file at name.
+func readModulePath(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+		modulePath := strings.Trim(fields[1], "\"`")
+		if modulePath != "" {
+			return modulePath, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrapf(err, "error reading %s", name)
+	}
+	return "", errors.Errorf("no module path in %s", name)
+}
+
+// load looks up the directories of the packages of files outside the main module with a single "go list" run.
+// Packages that can't be found are left unresolved.
+func (r *sourceResolver) load(files []string) {
+	var pkgs []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		pkg := path.Dir(file)
+		if _, ok := r.moduleRelPath(file); ok || filepath.IsAbs(file) || seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return
+	}
+	listed, err := goList(BuildOptions{}, nil, append([]string{"-e"}, pkgs...)...)
+	if err != nil {
+		return
+	}
+	for _, pkg := range listed {
+		if pkg.Dir != "" {
+			r.packageDirs[pkg.ImportPath] = pkg.Dir
+		}
+	}
+}
+
+// moduleRelPath returns the slash-separated path of file relative to the root of the main module,
+// if file belongs to the main module.
+func (r *sourceResolver) moduleRelPath(file string) (string, bool) {
+	if r.modulePath == "" || !strings.HasPrefix(file, r.modulePath+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(file, r.modulePath+"/"), true
+}
+
+// resolve returns the path on disk of file, or false if it can't be found.
+func (r *sourceResolver) resolve(file string) (string, bool) {
+	if filepath.IsAbs(file) {
+		return file, true
+	}
+	if rel, ok := r.moduleRelPath(file); ok {
+		return filepath.Join(r.moduleDir, filepath.FromSlash(rel)), true
+	}
+	if dir, ok := r.packageDirs[path.Dir(file)]; ok {
+		return filepath.Join(dir, path.Base(file)), true
+	}
+	return "", false
+}
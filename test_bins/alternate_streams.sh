@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+for i in $(seq 1 300); do
+  echo "out $i"
+  echo "err $i" >&2
+done
+echo START_BINCOVER_METADATA
+echo "{\"cover_mode\":\"\",\"exit_code\":0}"
+echo END_BINCOVER_METADATA
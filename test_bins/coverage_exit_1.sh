@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+for arg in "$@"; do
+  case $arg in
+    -test.coverprofile=*) printf "mode: set\na.go:1.1,2.2 1 1\n" > "${arg#*=}" ;;
+  esac
+done
+echo Wrote coverage profile
+exit 1
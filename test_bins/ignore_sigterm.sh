@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+trap "" TERM
+echo Ignoring SIGTERM
+exec sleep 10
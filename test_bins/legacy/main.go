@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Args: %s\n", strings.Join(os.Args[1:], " "))
+	fmt.Printf("Input: %s\n", input)
+}
@@ -0,0 +1,14 @@
+//go:build testrunmain
+// +build testrunmain
+
+package main
+
+import (
+	"testing"
+
+	"github.com/confluentinc/bincover/test_bins/legacy/bincover"
+)
+
+func TestRunMain(t *testing.T) {
+	bincover.RunTest(main)
+}
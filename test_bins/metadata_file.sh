@@ -0,0 +1,9 @@
+#!/usr/bin/env bash
+echo START_BINCOVER_METADATA
+echo "Printed by the binary itself"
+echo END_BINCOVER_METADATA
+for arg in "$@"; do
+  case $arg in
+    -metadata-file=*) printf "{\"cover_mode\":\"\",\"exit_code\":2}" > "${arg#*=}" ;;
+  esac
+done
@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+echo "To stdout"
+echo START_BINCOVER_METADATA >&2
+echo "{\"cover_mode\":\"\",\"exit_code\":3}" >&2
+echo END_BINCOVER_METADATA >&2
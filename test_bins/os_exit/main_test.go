@@ -1,4 +1,5 @@
-// +build testbincover
+//go:build testrunmain
+// +build testrunmain
 
 package main
 
@@ -8,6 +9,6 @@ import (
 	"github.com/confluentinc/bincover"
 )
 
-func TestBincoverRunMain(t *testing.T) {
+func TestRunMain(t *testing.T) {
 	bincover.RunTest(main)
 }
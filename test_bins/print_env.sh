@@ -0,0 +1,7 @@
+#!/usr/bin/env bash
+echo "BINCOVER_STRAY=${BINCOVER_STRAY-unset}"
+echo "BINCOVER_CUSTOM=${BINCOVER_CUSTOM-unset}"
+[ -n "$HOME" ] && echo "HOME is set"
+echo START_BINCOVER_METADATA
+echo "{\"cover_mode\":\"\",\"exit_code\":0}"
+echo END_BINCOVER_METADATA
@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/confluentinc/bincover"
+)
+
+func main() {
+	if !isTerminal(os.Stdin) {
+		fmt.Println("stdin is not a terminal")
+		bincover.ExitCode = 1
+		return
+	}
+	fmt.Print("Name: ")
+	name, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println(err)
+		bincover.ExitCode = 1
+		return
+	}
+	fmt.Printf("Hello, %s", name)
+}
+
+func isTerminal(file *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
@@ -0,0 +1,14 @@
+//go:build testrunmain
+// +build testrunmain
+
+package main
+
+import (
+	"testing"
+
+	"github.com/confluentinc/bincover"
+)
+
+func TestRunMain(t *testing.T) {
+	bincover.RunTest(main)
+}
@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+[ "$PWD" = "$HOME" ] && echo "Running in HOME"
+mkdir -p "$XDG_CONFIG_HOME/ourcli"
+echo "written by binary" > "$XDG_CONFIG_HOME/ourcli/config"
+[ -f data/settings.txt ] && cat data/settings.txt
+echo START_BINCOVER_METADATA
+echo "{\"cover_mode\":\"\",\"exit_code\":0}"
+echo END_BINCOVER_METADATA
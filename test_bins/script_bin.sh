@@ -0,0 +1,13 @@
+#!/usr/bin/env bash
+# Echoes its args, copies input.txt to output.txt if it exists, and exits with $EXIT_CODE.
+for arg in "$@"; do
+  case $arg in
+    -args-file=*) mapfile -d '' -t args < "${arg#*=}" ;;
+  esac
+done
+echo "args: ${args[*]}"
+[ -n "$WARNING" ] && echo "warning: $WARNING" >&2
+[ -f input.txt ] && cp input.txt output.txt
+echo START_BINCOVER_METADATA
+echo "{\"cover_mode\":\"\",\"exit_code\":${EXIT_CODE:-0}}"
+echo END_BINCOVER_METADATA
@@ -0,0 +1,3 @@
+#!/usr/bin/env bash
+echo Going to sleep
+exec sleep 10
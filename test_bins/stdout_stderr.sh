@@ -0,0 +1,6 @@
+#!/usr/bin/env bash
+echo "To stdout"
+echo "To stderr" >&2
+echo START_BINCOVER_METADATA
+echo "{\"cover_mode\":\"\",\"exit_code\":3}"
+echo END_BINCOVER_METADATA
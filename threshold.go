@@ -0,0 +1,138 @@
+package bincover
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// coverageThresholds holds the minimum statement coverage percentages TearDown enforces.
+type coverageThresholds struct {
+	// total is the minimum of the whole merged profile; zero disables the check.
+	total float64
+	// packages and files are keyed by import path and by file name as it appears in the profile.
+	packages map[string]float64
+	files    map[string]float64
+}
+
+// MinCoverage makes TearDown fail with a *CoverageThresholdError if the statement coverage of the merged profile
+// is below percent. Like the other threshold options, it must be passed to NewCoverageCollector.
+func MinCoverage(percent float64) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		c.thresholds.total = percent
+	}
+}
+
+// MinPackageCoverage makes TearDown fail with a *CoverageThresholdError if the statement coverage of the package
+// with import path pkg is below percent. A package missing from the merged profile has a coverage of 0%.
+func MinPackageCoverage(pkg string, percent float64) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		if c.thresholds.packages == nil {
+			c.thresholds.packages = make(map[string]float64)
+		}
+		c.thresholds.packages[pkg] = percent
+	}
+}
+
+// MinFileCoverage makes TearDown fail with a *CoverageThresholdError if the statement coverage of file is below
+// percent. file is named like in coverage profiles: the import path of its package followed by its base name,
+// e.g. "github.com/confluentinc/bincover/run_bin.go". A file missing from the merged profile has a coverage of 0%.
+func MinFileCoverage(file string, percent float64) CoverageCollectorOption {
+	return func(c *CoverageCollector) {
+		if c.thresholds.files == nil {
+			c.thresholds.files = make(map[string]float64)
+		}
+		c.thresholds.files[file] = percent
+	}
+}
+
+// CoverageThresholdError is returned by TearDown when coverage is below one of the minimums set with MinCoverage,
+// MinPackageCoverage or MinFileCoverage. The merged profile is written regardless.
+type CoverageThresholdError struct {
+	// Failures lists every check that failed: the total first, then packages and files ordered by name.
+	Failures []CoverageThresholdFailure
+}
+
+// CoverageThresholdFailure is a single failed coverage check.
+type CoverageThresholdFailure struct {
+	// Scope is "total", "package" or "file".
+	Scope string
+	// Name is the import path of the package or the name of the file. It is empty for the total.
+	Name string
+	// Minimum and Actual are statement coverage percentages.
+	Minimum float64
+	Actual  float64
+}
+
+func (f CoverageThresholdFailure) String() string {
+	name := f.Scope
+	if f.Name != "" {
+		name += " " + f.Name
+	}
+	return fmt.Sprintf("%s: %.1f%% < %.1f%%", name, f.Actual, f.Minimum)
+}
+
+func (e *CoverageThresholdError) Error() string {
+	lines := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		lines = append(lines, "\t"+f.String())
+	}
+	return fmt.Sprintf("coverage is below the minimum:\n%s", strings.Join(lines, "\n"))
+}
+
+// isZero reports whether no threshold is set.
+func (t coverageThresholds) isZero() bool {
+	return t.total == 0 && len(t.packages) == 0 && len(t.files) == 0
+}
+
+// check returns a *CoverageThresholdError listing every threshold p does not reach, or nil.
+func (t coverageThresholds) check(p *profile) error {
+	var total coverageStats
+	packages := make(map[string]*coverageStats)
+	files := make(map[string]*coverageStats)
+	for file, blocks := range p.files {
+		stats := &coverageStats{}
+		for _, block := range blocks {
+			stats.add(block)
+		}
+		files[file] = stats
+		pkg, ok := packages[path.Dir(file)]
+		if !ok {
+			pkg = &coverageStats{}
+			packages[path.Dir(file)] = pkg
+		}
+		pkg.addStats(*stats)
+		total.addStats(*stats)
+	}
+	var failures []CoverageThresholdFailure
+	if t.total > 0 && total.Percent() < t.total {
+		failures = append(failures, CoverageThresholdFailure{Scope: "total", Minimum: t.total, Actual: total.Percent()})
+	}
+	failures = append(failures, checkThresholds("package", t.packages, packages)...)
+	failures = append(failures, checkThresholds("file", t.files, files)...)
+	if len(failures) == 0 {
+		return nil
+	}
+	return &CoverageThresholdError{Failures: failures}
+}
+
+// checkThresholds returns the failed checks of minimums against stats, ordered by name.
+func checkThresholds(scope string, minimums map[string]float64, stats map[string]*coverageStats) []CoverageThresholdFailure {
+	names := make([]string, 0, len(minimums))
+	for name := range minimums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failures []CoverageThresholdFailure
+	for _, name := range names {
+		actual := 0.0
+		if s, ok := stats[name]; ok {
+			actual = s.Percent()
+		}
+		if actual < minimums[name] {
+			failures = append(failures, CoverageThresholdFailure{Scope: scope, Name: name, Minimum: minimums[name], Actual: actual})
+		}
+	}
+	return failures
+}
@@ -0,0 +1,103 @@
+package bincover
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const thresholdTestProfile = `mode: set
+example.com/app/cmd/main.go:1.1,2.2 3 1
+example.com/app/cmd/main.go:3.1,4.2 1 0
+example.com/app/internal/util.go:1.1,2.2 2 0
+example.com/app/internal/config.go:1.1,2.2 2 1
+`
+
+func Test_coverageThresholds_check(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      []CoverageCollectorOption
+		wantFailures []CoverageThresholdFailure
+	}{
+		{
+			name:    "succeed without thresholds",
+			options: nil,
+		},
+		{
+			name:    "succeed reaching every threshold",
+			options: []CoverageCollectorOption{MinCoverage(62.5), MinPackageCoverage("example.com/app/cmd", 75), MinFileCoverage("example.com/app/internal/config.go", 100)},
+		},
+		{
+			name: "fail below thresholds",
+			options: []CoverageCollectorOption{
+				MinCoverage(70),
+				MinPackageCoverage("example.com/app/internal", 60),
+				MinPackageCoverage("example.com/app/cmd", 75),
+				MinFileCoverage("example.com/app/internal/util.go", 10),
+				MinFileCoverage("example.com/app/cmd/main.go", 80),
+			},
+			wantFailures: []CoverageThresholdFailure{
+				{Scope: "total", Minimum: 70, Actual: 62.5},
+				{Scope: "package", Name: "example.com/app/internal", Minimum: 60, Actual: 50},
+				{Scope: "file", Name: "example.com/app/cmd/main.go", Minimum: 80, Actual: 75},
+				{Scope: "file", Name: "example.com/app/internal/util.go", Minimum: 10, Actual: 0},
+			},
+		},
+		{
+			name:    "fail with package missing from profile",
+			options: []CoverageCollectorOption{MinPackageCoverage("example.com/app/missing", 1)},
+			wantFailures: []CoverageThresholdFailure{
+				{Scope: "package", Name: "example.com/app/missing", Minimum: 1, Actual: 0},
+			},
+		},
+	}
+	p, err := parseProfile(strings.NewReader(thresholdTestProfile))
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoverageCollector("", true, tt.options...)
+			err := c.thresholds.check(p)
+			if tt.wantFailures == nil {
+				require.NoError(t, err)
+				return
+			}
+			var thresholdErr *CoverageThresholdError
+			require.True(t, errors.As(err, &thresholdErr))
+			require.Equal(t, tt.wantFailures, thresholdErr.Failures)
+		})
+	}
+}
+
+func TestCoverageCollector_TearDown_thresholds(t *testing.T) {
+	dir := t.TempDir()
+	profileFilename := filepath.Join(dir, "profile.out")
+	require.NoError(t, os.WriteFile(profileFilename, []byte(thresholdTestProfile), 0600))
+	mergedFilename := filepath.Join(dir, "merged.out")
+	c := NewCoverageCollector(mergedFilename, true, MinCoverage(90), MinFileCoverage("example.com/app/cmd/main.go", 80))
+	require.NoError(t, c.Setup())
+	require.NoError(t, c.AddProfile(profileFilename))
+	err := c.TearDown()
+	require.EqualError(t, err, "coverage is below the minimum:\n\ttotal: 62.5% < 90.0%\n\tfile example.com/app/cmd/main.go: 75.0% < 80.0%")
+	require.FileExists(t, mergedFilename)
+}
+
+func TestCoverageCollector_TearDown_thresholdsWithoutCoverage(t *testing.T) {
+	mergedFilename := filepath.Join(t.TempDir(), "merged.out")
+	c := NewCoverageCollector(mergedFilename, true, MinCoverage(80), MinPackageCoverage("example.com/app", 50))
+	require.NoError(t, c.Setup())
+	err := c.TearDown()
+	require.EqualError(t, err, "coverage is below the minimum:\n\ttotal: 0.0% < 80.0%\n\tpackage example.com/app: 0.0% < 50.0%")
+	require.NoFileExists(t, mergedFilename)
+}
+
+func TestCoverageCollector_TearDown_thresholdsWithCoverageDisabled(t *testing.T) {
+	c := NewCoverageCollector("", false, MinCoverage(80))
+	require.NoError(t, c.Setup())
+	_, _, err := c.RunBinary("./set_covermode", "TestRunMain", nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, c.TearDown())
+}
@@ -0,0 +1,72 @@
+package bincover
+
+import (
+	"bytes"
+	"strings"
+)
+
+// txtarArchive is a txtar archive: a comment followed by files, each introduced by a "-- name --" marker line.
+// See https://pkg.go.dev/golang.org/x/tools/txtar for the format.
+type txtarArchive struct {
+	comment string
+	files   []txtarFile
+}
+
+type txtarFile struct {
+	name string
+	data []byte
+}
+
+const (
+	txtarMarkerStart = "-- "
+	txtarMarkerEnd   = " --"
+)
+
+// parseTxtar parses data as a txtar archive. Every input is a valid archive.
+func parseTxtar(data []byte) *txtarArchive {
+	a := &txtarArchive{}
+	comment, name, data := splitTxtarFile(data)
+	a.comment = string(comment)
+	for name != "" {
+		var fileData []byte
+		var next string
+		fileData, next, data = splitTxtarFile(data)
+		a.files = append(a.files, txtarFile{name: name, data: fileData})
+		name = next
+	}
+	return a
+}
+
+// splitTxtarFile splits data at the first marker line, returning the data before it,
+// the name in the marker, and the data after it.
+func splitTxtarFile(data []byte) (before []byte, name string, after []byte) {
+	for i := 0; i < len(data); {
+		line := data[i:]
+		end := bytes.IndexByte(line, '\n')
+		if end != -1 {
+			line = line[:end+1]
+		}
+		if n, ok := txtarMarkerName(line); ok {
+			return fixTxtarNewline(data[:i]), n, data[i+len(line):]
+		}
+		i += len(line)
+	}
+	return fixTxtarNewline(data), "", nil
+}
+
+func txtarMarkerName(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, txtarMarkerStart) || !strings.HasSuffix(s, txtarMarkerEnd) || len(s) < len(txtarMarkerStart)+len(txtarMarkerEnd) {
+		return "", false
+	}
+	name := strings.TrimSpace(s[len(txtarMarkerStart) : len(s)-len(txtarMarkerEnd)])
+	return name, name != ""
+}
+
+// fixTxtarNewline adds a final newline to non-empty data that lacks one, as the txtar format requires.
+func fixTxtarNewline(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	return append(data[:len(data):len(data)], '\n')
+}